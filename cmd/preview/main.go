@@ -0,0 +1,38 @@
+// Command preview renders an email with fixture data and writes it to
+// stdout. Run it from the root of the repo so that the templates can be
+// found. For example:
+//
+//	go run ./cmd/preview -kind thread -locale es > /tmp/thread.html
+//	go run ./cmd/preview -kind digest -text
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hiconvo/api/template"
+	"github.com/hiconvo/api/utils/i18n"
+)
+
+func main() {
+	kind := flag.String("kind", template.PreviewThread,
+		fmt.Sprintf("kind of email to render (%s)", strings.Join(template.PreviewKinds, ", ")))
+	locale := flag.String("locale", i18n.Default,
+		fmt.Sprintf("locale to render in (%s)", strings.Join(i18n.Locales(), ", ")))
+	text := flag.Bool("text", false, "render the plain text version instead of HTML")
+	flag.Parse()
+
+	plainText, html, err := template.RenderPreview(*kind, *locale)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *text {
+		fmt.Print(plainText)
+	} else {
+		fmt.Print(html)
+	}
+}
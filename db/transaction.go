@@ -19,6 +19,12 @@ func TransactionFromContext(ctx context.Context) (Transaction, bool) {
 	return tx, ok
 }
 
+// WithoutTransaction returns a copy of ctx without its transaction, if it
+// has one, for work that is done after the transaction is committed.
+func WithoutTransaction(ctx context.Context) context.Context {
+	return context.WithValue(ctx, txKey, nil)
+}
+
 // AddTransactionToContext returns a new context with a transaction added.
 func AddTransactionToContext(ctx context.Context) (context.Context, Transaction, error) {
 	tx, err := DefaultClient.NewTransaction(ctx)
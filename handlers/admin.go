@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/mail"
+	"github.com/hiconvo/api/models"
+	"github.com/hiconvo/api/template"
+	"github.com/hiconvo/api/utils/bjson"
+)
+
+// GetSentEmails Endpoint: GET /admin/emails
+//
+// GetSentEmails returns the most recently sent emails, newest first. It
+// responds with a 404 when the outbox is disabled.
+func GetSentEmails(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.GetSentEmails")
+	ctx := r.Context()
+	p := getPagination(r)
+
+	if mail.DefaultOutbox == nil {
+		bjson.HandleError(w, errors.E(op, http.StatusNotFound, errors.Str("outbox is disabled")))
+		return
+	}
+
+	emails, err := mail.DefaultOutbox.GetAll(ctx, p.Offset(), p.Limit())
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, map[string][]*mail.Record{"emails": emails}, http.StatusOK)
+}
+
+// GetSentEmail Endpoint: GET /admin/emails/{emailID}
+//
+// GetSentEmail returns a single sent email including its content.
+func GetSentEmail(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.GetSentEmail")
+	ctx := r.Context()
+
+	if mail.DefaultOutbox == nil {
+		bjson.HandleError(w, errors.E(op, http.StatusNotFound, errors.Str("outbox is disabled")))
+		return
+	}
+
+	email, err := mail.DefaultOutbox.Get(ctx, mux.Vars(r)["emailID"])
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, email, http.StatusOK)
+}
+
+// PreviewEmail Endpoint: GET /admin/emails/preview/{kind}?locale=en&format=html
+//
+// PreviewEmail renders the given kind of email with fixture data. It
+// responds with HTML by default, or with plain text if format is "text".
+func PreviewEmail(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.PreviewEmail")
+	query := r.URL.Query()
+
+	plainText, html, err := template.RenderPreview(mux.Vars(r)["kind"], query.Get("locale"))
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if query.Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(plainText))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
+
+// Mailbox Endpoint: GET /admin/mailbox
+//
+// Mailbox serves a page for browsing the emails in the outbox. The page
+// itself contains no data. It asks for an admin's token and uses it to call
+// the endpoints above, so it doesn't need to be authenticated. It is only
+// served when emails are kept in memory for local development, and the
+// token is only kept for as long as the page is open.
+func Mailbox(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(mailboxHTML))
+}
+
+const mailboxHTML = `<!doctype html>
+<html>
+  <head>
+    <meta charset="utf-8" />
+    <title>Convo Mailbox</title>
+    <style>
+      body { display: flex; font-family: sans-serif; font-size: 14px; height: 100vh; margin: 0; }
+      #list { border-right: 1px solid #dddddd; overflow-y: auto; width: 360px; }
+      #list div { border-bottom: 1px solid #eeeeee; cursor: pointer; padding: 10px; }
+      #list div:hover, #list div.active { background: #f0f6fb; }
+      #list small { color: #777777; display: block; }
+      #list .failed, #list .suppressed { color: #c0392b; }
+      #detail { display: flex; flex: 1; flex-direction: column; }
+      #meta { border-bottom: 1px solid #dddddd; padding: 10px; white-space: pre-wrap; }
+      #meta button { margin-right: 5px; }
+      iframe, pre { border: 0; flex: 1; margin: 0; overflow: auto; padding: 10px; }
+    </style>
+  </head>
+  <body>
+    <div id="list"></div>
+    <div id="detail">
+      <div id="meta">Select an email.</div>
+      <iframe id="html" sandbox=""></iframe>
+      <pre id="text" hidden></pre>
+    </div>
+    <script>
+      var token = prompt("Admin token");
+
+      function get(path) {
+        return fetch(path, { headers: { Authorization: "Bearer " + token } }).then(function (rsp) {
+          if (!rsp.ok) {
+            throw new Error(rsp.status + " " + rsp.statusText);
+          }
+          return rsp.json();
+        });
+      }
+
+      function el(tag, text, className) {
+        var node = document.createElement(tag);
+        node.textContent = text;
+        if (className) node.className = className;
+        return node;
+      }
+
+      function show(id, row) {
+        document.querySelectorAll("#list div").forEach(function (n) { n.classList.remove("active"); });
+        row.classList.add("active");
+
+        get("/admin/emails/" + encodeURIComponent(id)).then(function (email) {
+          var meta = document.getElementById("meta");
+          meta.textContent =
+            "From: " + email.fromName + " <" + email.fromEmail + ">\n" +
+            "To: " + email.toName + " <" + email.toEmail + ">\n" +
+            "Subject: " + email.subject + "\n" +
+            "Date: " + email.createdAt + "\n" +
+            "Status: " + email.status + (email.error ? " (" + email.error + ")" : "") + "\n" +
+            (email.unsubscribeUrl ? "List-Unsubscribe: <" + email.unsubscribeUrl + ">\n" : "");
+
+          var html = document.getElementById("html");
+          var text = document.getElementById("text");
+          html.srcdoc = email.htmlContent;
+          text.textContent = email.textContent +
+            (email.icsAttachment ? "\n\n--- event.ics ---\n" + email.icsAttachment : "");
+
+          [["HTML", false], ["Text", true]].forEach(function (tab) {
+            var button = el("button", tab[0]);
+            button.onclick = function () {
+              html.hidden = tab[1];
+              text.hidden = !tab[1];
+            };
+            meta.insertBefore(button, meta.firstChild);
+          });
+        }).catch(alert);
+      }
+
+      get("/admin/emails?size=100").then(function (data) {
+        var list = document.getElementById("list");
+        data.emails.forEach(function (email) {
+          var row = el("div", email.subject);
+          row.appendChild(el("small", email.toEmail));
+          row.appendChild(el("small", email.createdAt + " " + email.status, email.status));
+          row.onclick = function () { show(email.id, row); };
+          list.appendChild(row);
+        });
+      }).catch(alert);
+    </script>
+  </body>
+</html>
+`
+
+// MigrateReads Endpoint: POST /admin/migrations/reads
+//
+// MigrateReads moves the reads stored on threads, events and messages into
+// read cursors. It responds with how many of each were migrated and can be
+// run again if it times out.
+func MigrateReads(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.MigrateReads")
+
+	migrated, err := models.MigrateReads(r.Context())
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, migrated, http.StatusOK)
+}
+
+// MigrateEventTimestamps Endpoint: POST /admin/migrations/events
+//
+// MigrateEventTimestamps saves the events that haven't happened yet again
+// so that they are found when digests list upcoming events. It responds
+// with how many were saved and can be run again if it times out.
+func MigrateEventTimestamps(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.MigrateEventTimestamps")
+
+	n, err := models.MigrateEventTimestamps(r.Context())
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, map[string]int{"events": n}, http.StatusOK)
+}
+
+// MigrateDigestSchedules Endpoint: POST /admin/migrations/digests
+//
+// MigrateDigestSchedules schedules the next digest of every user who
+// doesn't have one scheduled yet. It responds with how many users were
+// saved and can be run again if it times out.
+func MigrateDigestSchedules(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.MigrateDigestSchedules")
+
+	n, err := models.MigrateDigestSchedules(r.Context())
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, map[string]int{"users": n}, http.StatusOK)
+}
+
+// GetOrphanedBlobs Endpoint: GET /admin/blobs/orphans
+//
+// GetOrphanedBlobs reports the files in storage that the garbage collection
+// task would delete, without deleting them.
+func GetOrphanedBlobs(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.GetOrphanedBlobs")
+
+	report, err := models.CollectBlobGarbage(r.Context(), true)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, report, http.StatusOK)
+}
@@ -138,7 +138,10 @@ func GetEvents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bjson.WriteJSON(w, map[string][]*models.Event{"events": events}, http.StatusOK)
+	bjson.WriteJSON(w, map[string]interface{}{
+		"events":     events,
+		"nextCursor": p.Next,
+	}, http.StatusOK)
 }
 
 // GetEvent Endpoint: GET /events/{id}
@@ -312,11 +315,21 @@ func DeleteEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	messages, err := models.GetMessagesByEvent(ctx, &event, &models.Pagination{Size: -1})
+	if err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
 	if err := event.Delete(ctx); err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
+	// The files that were sent to the event no longer count toward the
+	// storage used by their senders
+	models.ReleaseStorage(ctx, messages)
+
 	if event.IsInFuture() {
 		if err := event.SendCancellation(ctx, html.UnescapeString(payload.Message)); err != nil {
 			bjson.HandleError(w, err)
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/storage"
+	"github.com/hiconvo/api/utils/bjson"
+)
+
+// GetFile Endpoint: GET /files?obj={key}&expiry={expiry}&signature={signature}
+
+// GetFile serves the file that a signed URL of a local bucket is for. This
+// is how photos and attachments are read in development, when files are kept
+// on disk instead of in cloud storage. It responds with a 404 otherwise.
+func GetFile(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.GetFile")
+	ctx := r.Context()
+
+	file, err := storage.DefaultClient.OpenSignedFile(ctx, r.URL)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+	defer file.Close()
+
+	if file.ContentType != "" {
+		w.Header().Set("Content-Type", file.ContentType)
+	}
+	if file.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", file.ContentDisposition)
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+
+	io.Copy(w, file)
+}
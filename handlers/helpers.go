@@ -212,5 +212,18 @@ func isHostsDifferent(eventHosts []*datastore.Key, payloadHosts []*models.User)
 func getPagination(r *http.Request) *models.Pagination {
 	pageNum, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	pageSize, _ := strconv.Atoi(r.URL.Query().Get("size"))
-	return &models.Pagination{Page: pageNum, Size: pageSize}
+	cursor := r.URL.Query().Get("cursor")
+	return &models.Pagination{Page: pageNum, Size: pageSize, Cursor: cursor}
+}
+
+// getMessagePagination is like getPagination, except that all messages are
+// returned when no page size is given, as they were before messages could
+// be paginated.
+func getMessagePagination(r *http.Request) *models.Pagination {
+	p := getPagination(r)
+	if p.Size == 0 && p.Cursor == "" {
+		p.Size = -1
+	}
+
+	return p
 }
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"html"
 	"net/http"
+	"strings"
 
 	"github.com/hiconvo/api/log"
 	"github.com/hiconvo/api/mail"
 	"github.com/hiconvo/api/models"
+	notif "github.com/hiconvo/api/notifications"
+	"github.com/hiconvo/api/utils/magic"
 	og "github.com/hiconvo/api/utils/opengraph"
 	"github.com/hiconvo/api/utils/pluck"
 	"github.com/hiconvo/api/utils/validate"
@@ -33,9 +36,20 @@ func Inbound(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get thread id from address
-	threadID, err := pluck.ThreadInt64IDFromAddress(to)
+	// The reply token in the address identifies the thread and the user
+	// the email was sent to. Addresses without a valid token, such as
+	// those of events, do not accept replies.
+	token := pluck.ReplyTokenFromAddress(to)
+	threadID, userID, err := magic.ParseReplyToken(token)
 	if err != nil {
+		// Emails sent before reply tokens only carry the ID of the thread,
+		// which can't be told apart from a forgery. Their senders are told
+		// to reply to a newer email instead.
+		if pluck.IsLegacyReplyToken(token) {
+			sendOutdatedAddressEmail(from)
+		} else {
+			sendTryAgainEmail(from)
+		}
 		handleClientErrorResponse(w, err)
 		return
 	}
@@ -48,17 +62,22 @@ func Inbound(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get user from from address
-	user, found, err := models.GetUserByEmail(ctx, from)
-	if !found {
-		sendErrorEmail(from)
-		handleClientErrorResponse(w, errors.New("Email not recognized"))
-		return
-	} else if err != nil {
+	// Get the user to whom the email was sent
+	user, err := models.GetUserByInt64ID(ctx, userID)
+	if err != nil {
 		handleClientErrorResponse(w, err)
 		return
 	}
 
+	// Reject replies that don't come from one of the recipient's own
+	// addresses. This happens when an email is forwarded and whoever
+	// received the copy responds to it.
+	if !(strings.EqualFold(user.Email, from) || user.HasEmail(from)) {
+		sendErrorEmail(from)
+		handleClientErrorResponse(w, errors.New("Sender does not match reply address"))
+		return
+	}
+
 	// Verify that the user is a particiapant of the thread
 	if !(thread.OwnerIs(&user) || thread.HasUser(&user)) {
 		sendErrorEmail(user.Email)
@@ -88,7 +107,7 @@ func Inbound(w http.ResponseWriter, r *http.Request) {
 	link := og.Extract(ctx, messageBody)
 
 	// Create the new message
-	message, err := models.NewThreadMessage(&user, &thread, messageBody, "", link)
+	message, err := models.NewThreadMessage(&user, &thread, messageBody, nil, nil, link, nil)
 	if err != nil {
 		handleServerErrorResponse(w, err)
 		return
@@ -104,11 +123,30 @@ func Inbound(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := models.MarkAsRead(ctx, &thread, user.Key); err != nil {
+		handleServerErrorResponse(w, err)
+		return
+	}
+
 	if err := thread.Send(ctx); err != nil {
 		handleServerErrorResponse(w, err)
 		return
 	}
 
+	if len(message.MentionKeys) > 0 {
+		if err := notif.Put(notif.Notification{
+			UserKeys:   message.MentionKeys,
+			Actor:      user.FullName,
+			Verb:       notif.Mention,
+			Target:     notif.Thread,
+			TargetID:   thread.ID,
+			TargetName: thread.Subject,
+		}); err != nil {
+			// Log the error but don't fail the request
+			log.Alarm(err)
+		}
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("PASS: message %s created", message.ID)))
 }
@@ -154,3 +192,19 @@ func sendTryAgainEmail(email string) {
 		log.Alarm(fmt.Errorf("handlers.sendTryAgainEmail: %v", err))
 	}
 }
+
+func sendOutdatedAddressEmail(email string) {
+	err := mail.Send(mail.EmailMessage{
+		FromName:    "Convo",
+		FromEmail:   "support@mail.convo.events",
+		ToName:      "",
+		ToEmail:     email,
+		Subject:     "[convo] Send Failure",
+		HTMLContent: "<p>Hello,</p><p>You responded to a Convo email that was sent before we changed the addresses that replies go to, so your message was not posted. Please reply to a more recent email from the Convo, or post your message on Convo directly.</p><p>Thanks,<br />Convo Support</p>",
+		TextContent: "Hello,\n\nYou responded to a Convo email that was sent before we changed the addresses that replies go to, so your message was not posted. Please reply to a more recent email from the Convo, or post your message on Convo directly.\n\nThanks,\nConvo Support",
+	})
+
+	if err != nil {
+		log.Alarm(fmt.Errorf("handlers.sendOutdatedAddressEmail: %v", err))
+	}
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
 	"html"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -34,21 +38,45 @@ func GetMessagesByThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages, err := models.GetMessagesByThread(ctx, &thread)
+	p := getMessagePagination(r)
+	messages, err := models.GetMessagesByThread(ctx, &thread, p)
 	if err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
-	bjson.WriteJSON(w, map[string][]*models.Message{"messages": messages}, http.StatusOK)
+	models.SetReadBy(messages, &thread, thread.Users, &u)
+
+	if err := models.SignMessageURLs(ctx, messages); err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
+	bjson.WriteJSON(w, map[string]interface{}{
+		"messages":   messages,
+		"nextCursor": p.Next,
+	}, http.StatusOK)
 }
 
 // AddMessageToThread Endpoint: POST /threads/:id/messages
 //
 // Request payload:
 type createMessagePayload struct {
-	Body string `validate:"nonzero"`
-	Blob string
+	Body        string `validate:"nonzero"`
+	Blob        string
+	Blobs       []interface{}
+	Attachments []interface{}
+	Uploads     []interface{}
+	ReplyTo     string
+}
+
+// attachmentPayload is an element of createMessagePayload.Attachments.
+// Type is the content type of the file and Blob is the file encoded in
+// base64.
+type attachmentPayload struct {
+	Name string `validate:"nonzero,max=255"`
+	Type string `validate:"nonzero"`
+	Blob string `validate:"nonzero"`
 }
 
 // AddMessageToThread adds a message to the given thread.
@@ -73,15 +101,28 @@ func AddMessageToThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var photoKey string
-	var err error
-	if payload.Blob != "" {
-		photoURL, err := storage.DefaultClient.PutPhotoFromBlob(ctx, thread.ID, payload.Blob)
+	var replyTo *models.Message
+	if payload.ReplyTo != "" {
+		m, err := models.GetReplyTarget(ctx, thread.Key, payload.ReplyTo)
 		if err != nil {
 			bjson.HandleError(w, errors.E(op, err))
 			return
 		}
-		photoKey = storage.DefaultClient.GetKeyFromPhotoURL(photoURL)
+		replyTo = m
+	}
+
+	// Quotas are checked and updated against what is stored, read within
+	// the transaction, so that concurrent messages can't both fit
+	author, parentUsed, err := models.GetStorageUsage(ctx, u.Key, thread.Key)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	files, err := putMessageFiles(ctx, author, thread.ID, parentUsed, &payload)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
 	}
 
 	messageBody := html.UnescapeString(payload.Body)
@@ -91,53 +132,75 @@ func AddMessageToThread(w http.ResponseWriter, r *http.Request) {
 		&u,
 		&thread,
 		messageBody,
-		photoKey,
+		files.photoKeys,
+		files.attachments,
 		link,
+		replyTo,
 	)
 	if err != nil {
 		bjson.HandleError(w, errors.E(op, err))
 		return
 	}
 
-	// Mark the message as read if it is the first message.
-	// Since it will be emailed to all members, it would be redundant
-	// to send in the daily digest.
-	if thread.ResponseCount == 1 {
-		for i := range thread.UserKeys {
-			models.MarkAsRead(&message, thread.UserKeys[i])
-		}
+	// Name the thread after the link, if included
+	if thread.ResponseCount == 1 && message.HasLink() && message.Link.Title != "" {
+		thread.Subject = message.Link.Title
+	}
+
+	message.PhotoBytes = files.photoBytes
+	thread.StorageUsed = parentUsed
+	thread.AddStorageUsed(message.StoredBytes())
 
-		// Name the thread after the link, if included
-		if message.HasLink() && message.Link.Title != "" {
-			thread.Subject = message.Link.Title
+	// The message is saved with the rest so that it is never saved
+	// without being counted
+	pendingKey, err := message.CommitWithTransaction(tx)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if _, err := thread.CommitWithTransaction(tx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if size := message.StoredBytes(); size > 0 {
+		author.AddStorageUsed(size)
+		if _, err := author.CommitWithTransaction(tx); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
 		}
 	}
 
-	if err := message.Commit(ctx); err != nil {
+	commit, err := tx.Commit()
+	if err != nil {
 		bjson.HandleError(w, errors.E(op, err))
 		return
 	}
 
-	if _, err := thread.CommitWithTransaction(tx); err != nil {
+	message.Key = commit.Key(pendingKey)
+	message.ID = message.Key.Encode()
+
+	models.DeleteUploads(ctx, files.uploads)
+
+	// The sender has read everything up to their own message
+	if err := models.MarkAsRead(ctx, &thread, u.Key); err != nil {
 		bjson.HandleError(w, errors.E(op, err))
 		return
 	}
 
-	if _, err := tx.Commit(); err != nil {
+	// Email the message to those who want it. Which members are sent
+	// what is determined by their notification preferences.
+	if err := thread.SendAsync(ctx); err != nil {
 		bjson.HandleError(w, errors.E(op, err))
 		return
 	}
 
-	if thread.ResponseCount == 1 {
-		// Only send the first message as an email
-		if err := thread.SendAsync(ctx); err != nil {
-			bjson.HandleError(w, errors.E(op, err))
-			return
-		}
-	} else {
-		// Send a notification for all later responses
+	// Send a notification for all later responses. Those who are mentioned
+	// get a mention notification instead.
+	if thread.ResponseCount > 1 {
 		if err := notif.Put(notif.Notification{
-			UserKeys:   notif.FilterKey(thread.UserKeys, u.Key),
+			UserKeys:   notif.FilterKeys(notif.FilterKey(thread.UserKeys, u.Key), message.MentionKeys),
 			Actor:      u.FullName,
 			Verb:       notif.NewMessage,
 			Target:     notif.Thread,
@@ -149,6 +212,25 @@ func AddMessageToThread(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if len(message.MentionKeys) > 0 {
+		if err := notif.Put(notif.Notification{
+			UserKeys:   message.MentionKeys,
+			Actor:      u.FullName,
+			Verb:       notif.Mention,
+			Target:     notif.Thread,
+			TargetID:   thread.ID,
+			TargetName: thread.Subject,
+		}); err != nil {
+			// Log the error but don't fail the request
+			log.Alarm(err)
+		}
+	}
+
+	if err := message.SignURLs(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
 	bjson.WriteJSON(w, message, http.StatusCreated)
 }
 
@@ -162,7 +244,7 @@ func DeleteThreadMessage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["messageID"]
 
-	messages, err := models.GetMessagesByThread(ctx, &thread)
+	messages, err := models.GetMessagesByThread(ctx, &thread, &models.Pagination{Size: -1})
 	if err != nil {
 		bjson.HandleError(w, errors.E(op, err))
 		return
@@ -199,18 +281,222 @@ func DeleteThreadMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	author, parentUsed, err := models.GetStorageUsage(ctx, u.Key, thread.Key)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
 	thread.ResponseCount--
+	thread.StorageUsed = parentUsed
+	thread.AddStorageUsed(-m.StoredBytes())
 
 	if _, err := thread.CommitWithTransaction(tx); err != nil {
 		bjson.HandleError(w, errors.E(op, err))
 		return
 	}
 
+	if size := m.StoredBytes(); size > 0 {
+		author.AddStorageUsed(-size)
+		if _, err := author.CommitWithTransaction(tx); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+	}
+
+	if _, err := tx.Commit(); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, m, http.StatusOK)
+}
+
+// EditThreadMessage Endpoint: PATCH /threads/{threadID}/messages/{messageID}
+//
+// Request payload:
+type editMessagePayload struct {
+	Body string `validate:"nonzero"`
+}
+
+// EditThreadMessage lets the author of a message change its body for a
+// while after sending it.
+func EditThreadMessage(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.EditThreadMessage")
+	ctx := r.Context()
+	tx, _ := db.TransactionFromContext(ctx)
+	u := middleware.UserFromContext(ctx)
+	thread := middleware.ThreadFromContext(ctx)
+	body := bjson.BodyFromContext(ctx)
+	vars := mux.Vars(r)
+	id := vars["messageID"]
+
+	var payload editMessagePayload
+	if err := validate.Do(&payload, body); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	m, err := models.GetMessageByID(ctx, id)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err, http.StatusNotFound))
+		return
+	}
+
+	if !thread.Key.Equal(m.ParentKey) {
+		bjson.HandleError(w, errors.E(op, errors.Str("MessageNotInThread"), http.StatusNotFound))
+		return
+	}
+
+	// Check permissions
+	if !(m.OwnerIs(&u)) {
+		bjson.HandleError(w, errors.E(op, errors.Str("NoPermission"), http.StatusNotFound))
+		return
+	}
+
+	messageBody := html.UnescapeString(payload.Body)
+	newMentions, err := m.Edit(messageBody, og.Extract(ctx, messageBody), thread.Users)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if _, err := m.CommitWithTransaction(tx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if thread.UpdatePreview(&m) {
+		if _, err := thread.CommitWithTransaction(tx); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+	}
+
+	if _, err := tx.Commit(); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	// Those who are mentioned for the first time get a mention notification
+	// instead
+	if err := notif.Put(notif.Notification{
+		UserKeys:   notif.FilterKeys(notif.FilterKey(thread.UserKeys, u.Key), newMentions),
+		Actor:      u.FullName,
+		Verb:       notif.EditMessage,
+		Target:     notif.Thread,
+		TargetID:   thread.ID,
+		TargetName: thread.Subject,
+	}); err != nil {
+		// Log the error but don't fail the request
+		log.Alarm(err)
+	}
+
+	if len(newMentions) > 0 {
+		if err := notif.Put(notif.Notification{
+			UserKeys:   newMentions,
+			Actor:      u.FullName,
+			Verb:       notif.Mention,
+			Target:     notif.Thread,
+			TargetID:   thread.ID,
+			TargetName: thread.Subject,
+		}); err != nil {
+			// Log the error but don't fail the request
+			log.Alarm(err)
+		}
+	}
+
+	m.User = models.MapUserToUserPartial(&u)
+
+	if err := m.SignURLs(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, m, http.StatusOK)
+}
+
+// AddReactionToThreadMessage Endpoint: POST /threads/{threadID}/messages/{messageID}/reactions
+//
+// Request payload:
+type reactionPayload struct {
+	Emoji string `validate:"nonzero"`
+}
+
+// AddReactionToThreadMessage reacts to a message with an emoji on behalf
+// of the current user. It doesn't change what anyone has read.
+func AddReactionToThreadMessage(w http.ResponseWriter, r *http.Request) {
+	updateThreadMessageReaction(w, r, errors.Op("handlers.AddReactionToThreadMessage"), true)
+}
+
+// RemoveReactionFromThreadMessage Endpoint: DELETE /threads/{threadID}/messages/{messageID}/reactions
+//
+// The request payload is the same as AddReactionToThreadMessage's.
+
+// RemoveReactionFromThreadMessage removes a reaction of the current user
+// from a message.
+func RemoveReactionFromThreadMessage(w http.ResponseWriter, r *http.Request) {
+	updateThreadMessageReaction(w, r, errors.Op("handlers.RemoveReactionFromThreadMessage"), false)
+}
+
+func updateThreadMessageReaction(w http.ResponseWriter, r *http.Request, op errors.Op, add bool) {
+	ctx := r.Context()
+	tx, _ := db.TransactionFromContext(ctx)
+	u := middleware.UserFromContext(ctx)
+	thread := middleware.ThreadFromContext(ctx)
+	body := bjson.BodyFromContext(ctx)
+	vars := mux.Vars(r)
+	id := vars["messageID"]
+
+	var payload reactionPayload
+	if err := validate.Do(&payload, body); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	// Check permissions
+	if !(thread.OwnerIs(&u) || thread.HasUser(&u)) {
+		bjson.HandleError(w, errors.E(op, errors.Str("NoPermission"), http.StatusNotFound))
+		return
+	}
+
+	m, err := models.GetMessageByID(ctx, id)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err, http.StatusNotFound))
+		return
+	}
+
+	if !thread.Key.Equal(m.ParentKey) {
+		bjson.HandleError(w, errors.E(op, errors.Str("MessageNotInThread"), http.StatusNotFound))
+		return
+	}
+
+	if add {
+		if err := m.AddReaction(&u, payload.Emoji); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+	} else {
+		m.RemoveReaction(&u, payload.Emoji)
+	}
+
+	if _, err := m.CommitWithTransaction(tx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
 	if _, err := tx.Commit(); err != nil {
 		bjson.HandleError(w, errors.E(op, err))
 		return
 	}
 
+	m.User = getMessageAuthor(&m, thread.Users)
+
+	if err := m.SignURLs(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
 	bjson.WriteJSON(w, m, http.StatusOK)
 }
 
@@ -230,13 +516,24 @@ func GetMessagesByEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages, err := models.GetMessagesByEvent(ctx, &event)
+	p := getMessagePagination(r)
+	messages, err := models.GetMessagesByEvent(ctx, &event, p)
 	if err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
-	bjson.WriteJSON(w, map[string][]*models.Message{"messages": messages}, http.StatusOK)
+	models.SetReadBy(messages, &event, event.Users, &u)
+
+	if err := models.SignMessageURLs(ctx, messages); err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
+	bjson.WriteJSON(w, map[string]interface{}{
+		"messages":   messages,
+		"nextCursor": p.Next,
+	}, http.StatusOK)
 }
 
 // AddMessageToEvent Endpoint: POST /events/:id/messages
@@ -246,6 +543,7 @@ func AddMessageToEvent(w http.ResponseWriter, r *http.Request) {
 	op := errors.Op("handlers.AddMessageToEvent")
 	ctx := r.Context()
 	u := middleware.UserFromContext(ctx)
+	tx, _ := db.TransactionFromContext(ctx)
 	event := middleware.EventFromContext(ctx)
 	body := bjson.BodyFromContext(ctx)
 
@@ -264,39 +562,88 @@ func AddMessageToEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var photoKey string
-	var err error
-	if payload.Blob != "" {
-		photoURL, err := storage.DefaultClient.PutPhotoFromBlob(ctx, event.ID, payload.Blob)
+	var replyTo *models.Message
+	if payload.ReplyTo != "" {
+		m, err := models.GetReplyTarget(ctx, event.Key, payload.ReplyTo)
 		if err != nil {
 			bjson.HandleError(w, errors.E(op, err))
 			return
 		}
-		photoKey = storage.DefaultClient.GetKeyFromPhotoURL(photoURL)
+		replyTo = m
+	}
+
+	// Quotas are checked and updated against what is stored, read within
+	// the transaction, so that concurrent messages can't both fit
+	author, parentUsed, err := models.GetStorageUsage(ctx, u.Key, event.Key)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	files, err := putMessageFiles(ctx, author, event.ID, parentUsed, &payload)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
 	}
 
 	message, err := models.NewEventMessage(
 		&u,
 		&event,
 		html.UnescapeString(payload.Body),
-		photoKey)
+		files.photoKeys,
+		files.attachments,
+		replyTo)
+	if err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
+	message.PhotoBytes = files.photoBytes
+	event.StorageUsed = parentUsed
+	event.AddStorageUsed(message.StoredBytes())
+
+	// The message is saved with the rest so that it is never saved
+	// without being counted
+	pendingKey, err := message.CommitWithTransaction(tx)
 	if err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
-	if err := message.Commit(ctx); err != nil {
+	if _, err := event.CommitWithTransaction(tx); err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
-	if err := event.Commit(ctx); err != nil {
+	if size := message.StoredBytes(); size > 0 {
+		author.AddStorageUsed(size)
+		if _, err := author.CommitWithTransaction(tx); err != nil {
+			bjson.HandleError(w, err)
+			return
+		}
+	}
+
+	commit, err := tx.Commit()
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	message.Key = commit.Key(pendingKey)
+	message.ID = message.Key.Encode()
+
+	models.DeleteUploads(ctx, files.uploads)
+
+	// The sender has read everything up to their own message
+	if err := models.MarkAsRead(ctx, &event, u.Key); err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
+	// Those who are mentioned get a mention notification and an email
+	// instead of the usual notification.
 	if err := notif.Put(notif.Notification{
-		UserKeys:   notif.FilterKey(event.UserKeys, u.Key),
+		UserKeys:   notif.FilterKeys(notif.FilterKey(event.UserKeys, u.Key), message.MentionKeys),
 		Actor:      u.FullName,
 		Verb:       notif.NewMessage,
 		Target:     notif.Event,
@@ -307,6 +654,30 @@ func AddMessageToEvent(w http.ResponseWriter, r *http.Request) {
 		log.Alarm(err)
 	}
 
+	if len(message.MentionKeys) > 0 {
+		if err := notif.Put(notif.Notification{
+			UserKeys:   message.MentionKeys,
+			Actor:      u.FullName,
+			Verb:       notif.Mention,
+			Target:     notif.Event,
+			TargetID:   event.ID,
+			TargetName: event.Name,
+		}); err != nil {
+			// Log the error but don't fail the request
+			log.Alarm(err)
+		}
+
+		if err := event.SendMentionsAsync(ctx); err != nil {
+			bjson.HandleError(w, err)
+			return
+		}
+	}
+
+	if err := message.SignURLs(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
 	bjson.WriteJSON(w, message, http.StatusCreated)
 }
 
@@ -314,6 +685,7 @@ func AddMessageToEvent(w http.ResponseWriter, r *http.Request) {
 func DeleteEventMessage(w http.ResponseWriter, r *http.Request) {
 	op := errors.Op("handlers.DeleteEventMessage")
 	ctx := r.Context()
+	tx, _ := db.TransactionFromContext(ctx)
 	u := middleware.UserFromContext(ctx)
 	event := middleware.EventFromContext(ctx)
 	vars := mux.Vars(r)
@@ -341,8 +713,209 @@ func DeleteEventMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if size := m.StoredBytes(); size > 0 {
+		author, parentUsed, err := models.GetStorageUsage(ctx, u.Key, event.Key)
+		if err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+
+		event.StorageUsed = parentUsed
+		event.AddStorageUsed(-size)
+		if _, err := event.CommitWithTransaction(tx); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+
+		author.AddStorageUsed(-size)
+		if _, err := author.CommitWithTransaction(tx); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+	}
+
+	if _, err := tx.Commit(); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	m.User = models.MapUserToUserPartial(&u)
+
+	bjson.WriteJSON(w, m, http.StatusOK)
+}
+
+// EditEventMessage Endpoint: PATCH /events/{eventID}/messages/{messageID}
+//
+// The request payload is the same as EditThreadMessage's.
+
+// EditEventMessage lets the author of a message change its body for a
+// while after sending it.
+func EditEventMessage(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.EditEventMessage")
+	ctx := r.Context()
+	tx, _ := db.TransactionFromContext(ctx)
+	u := middleware.UserFromContext(ctx)
+	event := middleware.EventFromContext(ctx)
+	body := bjson.BodyFromContext(ctx)
+	vars := mux.Vars(r)
+	id := vars["messageID"]
+
+	var payload editMessagePayload
+	if err := validate.Do(&payload, body); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	m, err := models.GetMessageByID(ctx, id)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err, http.StatusNotFound))
+		return
+	}
+
+	if !event.Key.Equal(m.ParentKey) {
+		bjson.HandleError(w, errors.E(op, errors.Str("MessageNotInEvent"), http.StatusNotFound))
+		return
+	}
+
+	// Check permissions
+	if !(m.OwnerIs(&u)) {
+		bjson.HandleError(w, errors.E(op, errors.Str("NoPermission"), http.StatusNotFound))
+		return
+	}
+
+	// Links aren't extracted from event messages, as when they are sent
+	newMentions, err := m.Edit(html.UnescapeString(payload.Body), og.LinkData{}, event.Users)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if _, err := m.CommitWithTransaction(tx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if _, err := tx.Commit(); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	// Those who are mentioned for the first time get a mention notification
+	// instead
+	if err := notif.Put(notif.Notification{
+		UserKeys:   notif.FilterKeys(notif.FilterKey(event.UserKeys, u.Key), newMentions),
+		Actor:      u.FullName,
+		Verb:       notif.EditMessage,
+		Target:     notif.Event,
+		TargetID:   event.ID,
+		TargetName: event.Name,
+	}); err != nil {
+		// Log the error but don't fail the request
+		log.Alarm(err)
+	}
+
+	if len(newMentions) > 0 {
+		if err := notif.Put(notif.Notification{
+			UserKeys:   newMentions,
+			Actor:      u.FullName,
+			Verb:       notif.Mention,
+			Target:     notif.Event,
+			TargetID:   event.ID,
+			TargetName: event.Name,
+		}); err != nil {
+			// Log the error but don't fail the request
+			log.Alarm(err)
+		}
+	}
+
 	m.User = models.MapUserToUserPartial(&u)
 
+	if err := m.SignURLs(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, m, http.StatusOK)
+}
+
+// AddReactionToEventMessage Endpoint: POST /events/{eventID}/messages/{messageID}/reactions
+//
+// The request payload is the same as AddReactionToThreadMessage's.
+
+// AddReactionToEventMessage reacts to a message with an emoji on behalf
+// of the current user. It doesn't change what anyone has read.
+func AddReactionToEventMessage(w http.ResponseWriter, r *http.Request) {
+	updateEventMessageReaction(w, r, errors.Op("handlers.AddReactionToEventMessage"), true)
+}
+
+// RemoveReactionFromEventMessage Endpoint: DELETE /events/{eventID}/messages/{messageID}/reactions
+//
+// The request payload is the same as AddReactionToThreadMessage's.
+
+// RemoveReactionFromEventMessage removes a reaction of the current user
+// from a message.
+func RemoveReactionFromEventMessage(w http.ResponseWriter, r *http.Request) {
+	updateEventMessageReaction(w, r, errors.Op("handlers.RemoveReactionFromEventMessage"), false)
+}
+
+func updateEventMessageReaction(w http.ResponseWriter, r *http.Request, op errors.Op, add bool) {
+	ctx := r.Context()
+	tx, _ := db.TransactionFromContext(ctx)
+	u := middleware.UserFromContext(ctx)
+	event := middleware.EventFromContext(ctx)
+	body := bjson.BodyFromContext(ctx)
+	vars := mux.Vars(r)
+	id := vars["messageID"]
+
+	var payload reactionPayload
+	if err := validate.Do(&payload, body); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	// Check permissions
+	if !(event.OwnerIs(&u) || event.HasUser(&u)) {
+		bjson.HandleError(w, errors.E(op, errors.Str("NoPermission"), http.StatusNotFound))
+		return
+	}
+
+	m, err := models.GetMessageByID(ctx, id)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err, http.StatusNotFound))
+		return
+	}
+
+	if !event.Key.Equal(m.ParentKey) {
+		bjson.HandleError(w, errors.E(op, errors.Str("MessageNotInEvent"), http.StatusNotFound))
+		return
+	}
+
+	if add {
+		if err := m.AddReaction(&u, payload.Emoji); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+	} else {
+		m.RemoveReaction(&u, payload.Emoji)
+	}
+
+	if _, err := m.CommitWithTransaction(tx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if _, err := tx.Commit(); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	m.User = getMessageAuthor(&m, event.Users)
+
+	if err := m.SignURLs(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
 	bjson.WriteJSON(w, m, http.StatusOK)
 }
 
@@ -399,5 +972,200 @@ func DeletePhotoFromMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := m.SignURLs(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
 	bjson.WriteJSON(w, m, http.StatusOK)
 }
+
+// getMessageAuthor returns the author of m from among the given members of
+// its thread or event, or nil if they are no longer a member.
+func getMessageAuthor(m *models.Message, users []*models.User) *models.UserPartial {
+	for i := range users {
+		if m.OwnerIs(users[i]) {
+			return models.MapUserToUserPartial(users[i])
+		}
+	}
+
+	return nil
+}
+
+// messageFiles are the photos and attachments that were saved for a new
+// message. photoBytes is how many bytes were sent of each photo. uploads
+// are the uploads that were stored, which are deleted once the message is.
+type messageFiles struct {
+	photoKeys   []string
+	photoBytes  []int
+	attachments []*models.Attachment
+	uploads     []*models.Upload
+}
+
+// putMessageFiles stores the photos and attachments of a new message of the
+// thread or event with the given ID, and returns the keys of the photos and
+// the attachments. Blob is treated as the first of Blobs. Uploads are added
+// after the blobs or attachments, depending on whether they are photos, and
+// are returned to be deleted once the message is saved. Everything is checked before anything is stored,
+// including that the files fit within u's quota and within that of the
+// thread or event, which already stores parentStorageUsed bytes.
+func putMessageFiles(
+	ctx context.Context,
+	u *models.User,
+	parentID string,
+	parentStorageUsed int,
+	payload *createMessagePayload,
+) (*messageFiles, error) {
+	op := errors.Op("handlers.putMessageFiles")
+
+	blobs := payload.Blobs
+	if payload.Blob != "" {
+		blobs = append([]interface{}{payload.Blob}, blobs...)
+	}
+
+	if len(blobs) > models.MaxPhotosPerMessage {
+		return nil, errors.E(op, errors.Str("TooManyPhotos"), http.StatusBadRequest,
+			map[string]string{"blobs": fmt.Sprintf("You can send up to %d photos at a time", models.MaxPhotosPerMessage)})
+	}
+
+	if len(payload.Attachments) > models.MaxAttachmentsPerMessage {
+		return nil, errors.E(op, errors.Str("TooManyAttachments"), http.StatusBadRequest,
+			map[string]string{"attachments": fmt.Sprintf("You can send up to %d attachments at a time", models.MaxAttachmentsPerMessage)})
+	}
+
+	photos := make([]string, len(blobs))
+	for i := range blobs {
+		dat, ok := blobs[i].(string)
+		if !ok || dat == "" {
+			return nil, errors.E(op, errors.Str("InvalidBlobs"), http.StatusBadRequest,
+				map[string]string{"blobs": "Blobs must be an array of strings"})
+		}
+
+		if decodedLen(dat) > models.MaxPhotoSize {
+			return nil, errors.E(op, errors.Str("PhotoTooLarge"), http.StatusBadRequest,
+				map[string]string{"blobs": "Photos must be smaller than 10 MB"})
+		}
+
+		photos[i] = dat
+	}
+
+	files := make([]attachmentPayload, len(payload.Attachments))
+	for i := range payload.Attachments {
+		fileMap, ok := payload.Attachments[i].(map[string]interface{})
+		if !ok {
+			return nil, errors.E(op, errors.Str("InvalidAttachments"), http.StatusBadRequest,
+				map[string]string{"attachments": "Attachments must be an array of objects"})
+		}
+
+		if err := validate.Do(&files[i], fileMap); err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		if err := models.ValidateAttachment(files[i].Name, files[i].Type, decodedLen(files[i].Blob)); err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	var photoUploads, fileUploads []*models.Upload
+	for i := range payload.Uploads {
+		id, ok := payload.Uploads[i].(string)
+		if !ok {
+			return nil, errors.E(op, errors.Str("InvalidUploads"), http.StatusBadRequest,
+				map[string]string{"uploads": "Uploads must be an array of IDs"})
+		}
+
+		upload, err := models.GetCompleteUpload(ctx, u, id, "uploads")
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		if upload.IsPhoto() {
+			photoUploads = append(photoUploads, upload)
+		} else {
+			fileUploads = append(fileUploads, upload)
+		}
+	}
+
+	if len(photos)+len(photoUploads) > models.MaxPhotosPerMessage {
+		return nil, errors.E(op, errors.Str("TooManyPhotos"), http.StatusBadRequest,
+			map[string]string{"uploads": fmt.Sprintf("You can send up to %d photos at a time", models.MaxPhotosPerMessage)})
+	}
+
+	if len(files)+len(fileUploads) > models.MaxAttachmentsPerMessage {
+		return nil, errors.E(op, errors.Str("TooManyAttachments"), http.StatusBadRequest,
+			map[string]string{"uploads": fmt.Sprintf("You can send up to %d attachments at a time", models.MaxAttachmentsPerMessage)})
+	}
+
+	// Files count toward quotas as they were sent
+	var size int
+	for i := range photos {
+		size += decodedLen(photos[i])
+	}
+	for _, f := range files {
+		size += decodedLen(f.Blob)
+	}
+	for _, up := range append(photoUploads, fileUploads...) {
+		size += up.Size
+	}
+
+	quotaField := "blobs"
+	if len(payload.Uploads) > 0 {
+		quotaField = "uploads"
+	} else if len(photos) == 0 {
+		quotaField = "attachments"
+	}
+
+	if err := models.CheckStorageQuota(u, parentStorageUsed, size, quotaField); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	saved := &messageFiles{}
+	for i := range photos {
+		photoURL, err := storage.DefaultClient.PutPhotoFromBlob(ctx, parentID, photos[i])
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		saved.photoKeys = append(saved.photoKeys, storage.DefaultClient.GetKeyFromPhotoURL(photoURL))
+		saved.photoBytes = append(saved.photoBytes, decodedLen(photos[i]))
+	}
+
+	for _, f := range files {
+		key, err := storage.DefaultClient.PutFileFromBlob(ctx, parentID, f.Name, f.Type, f.Blob)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		saved.attachments = append(saved.attachments, models.NewAttachment(key, f.Name, f.Type, decodedLen(f.Blob)))
+	}
+
+	for _, up := range photoUploads {
+		photoURL, err := storage.DefaultClient.PutPhotoFromUpload(ctx, parentID, up.BlobKey)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		saved.photoKeys = append(saved.photoKeys, storage.DefaultClient.GetKeyFromPhotoURL(photoURL))
+		saved.photoBytes = append(saved.photoBytes, up.Size)
+	}
+
+	for _, up := range fileUploads {
+		key, err := storage.DefaultClient.PutFileFromUpload(ctx, parentID, up.Name, up.ContentType, up.BlobKey)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		saved.attachments = append(saved.attachments, models.NewAttachment(key, up.Name, up.ContentType, up.Size))
+	}
+
+	saved.uploads = append(photoUploads, fileUploads...)
+
+	return saved, nil
+}
+
+// decodedLen returns the number of bytes that the given base64 string
+// decodes to.
+func decodedLen(dat string) int {
+	padding := len(dat) - len(strings.TrimRight(dat, "="))
+	return base64.StdEncoding.DecodedLen(len(dat)) - padding
+}
@@ -1,12 +1,8 @@
 package handlers
 
 import (
-	"context"
 	"net/http"
 
-	"cloud.google.com/go/datastore"
-
-	"github.com/hiconvo/api/db"
 	"github.com/hiconvo/api/errors"
 	"github.com/hiconvo/api/middleware"
 	"github.com/hiconvo/api/models"
@@ -26,19 +22,13 @@ func MarkThreadAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := markMessagesAsRead(ctx, &thread, &user, thread.Key); err != nil {
+	if err := models.MarkAsRead(ctx, &thread, user.Key); err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
-	models.MarkAsRead(&thread, user.Key)
 	thread.UserReads = models.MapReadsToUserPartials(&thread, thread.Users)
 
-	if err := thread.Commit(ctx); err != nil {
-		bjson.HandleError(w, err)
-		return
-	}
-
 	bjson.WriteJSON(w, thread, http.StatusOK)
 }
 
@@ -55,42 +45,29 @@ func MarkEventAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := markMessagesAsRead(ctx, &event, &user, event.Key); err != nil {
+	if err := models.MarkAsRead(ctx, &event, user.Key); err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
-	models.MarkAsRead(&event, user.Key)
 	event.UserReads = models.MapReadsToUserPartials(&event, event.Users)
 
-	if err := event.Commit(ctx); err != nil {
-		bjson.HandleError(w, err)
-		return
-	}
-
 	bjson.WriteJSON(w, event, http.StatusOK)
 }
 
-func markMessagesAsRead(
-	ctx context.Context,
-	readable models.Readable,
-	user *models.User,
-	key *datastore.Key,
-) error {
-	messages, err := models.GetMessagesByKey(ctx, key)
-	if err != nil {
-		return err
-	}
-
-	messageKeys := make([]*datastore.Key, len(messages))
-	for i := range messages {
-		models.MarkAsRead(messages[i], user.Key)
-		messageKeys[i] = messages[i].Key
-	}
+// GetUnreadCounts Endpoint: GET /users/unread
+//
+// GetUnreadCounts returns how many threads and events the user hasn't
+// read, and how many unread messages each of them has.
+func GetUnreadCounts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := middleware.UserFromContext(ctx)
 
-	if _, err := db.DefaultClient.PutMulti(ctx, messageKeys, messages); err != nil {
-		return err
+	counts, err := models.GetUnreadCountsByUser(ctx, &user)
+	if err != nil {
+		bjson.HandleError(w, errors.E(errors.Op("handlers.GetUnreadCounts"), err))
+		return
 	}
 
-	return nil
+	bjson.WriteJSON(w, counts, http.StatusOK)
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/hiconvo/api/db"
+	"github.com/hiconvo/api/mail"
 	"github.com/hiconvo/api/middleware"
 	"github.com/hiconvo/api/utils/bjson"
 )
@@ -24,12 +25,48 @@ func New() http.Handler {
 
 	router.HandleFunc("/inbound", Inbound).Methods("POST")
 
+	////
+	// Email delivery events webhook
+	////
+
+	router.HandleFunc("/webhooks/email", EmailEvents).Methods("POST")
+
+	////
+	// Unsubscribe links
+	////
+
+	router.HandleFunc("/unsubscribe/{category}/{userID}/{signature}", Unsubscribe).Methods("GET", "POST")
+
+	////
+	// Outbound email viewer, only in local development
+	////
+
+	if mail.IsMemoryOutbox() {
+		router.HandleFunc("/admin/mailbox", Mailbox).Methods("GET")
+	}
+
+	////
+	// Files in local storage, which are read with signed URLs
+	////
+
+	router.HandleFunc("/files", GetFile).Methods("GET")
+
 	////
 	// Async tasks
 	////
 
 	router.HandleFunc("/tasks/digest", CreateDigest)
 	router.HandleFunc("/tasks/emails", SendEmailsAsync)
+	router.HandleFunc("/tasks/gc", CollectGarbage)
+
+	////
+	// Upload endpoints
+	////
+
+	uploadSubrouter := router.NewRoute().Subrouter()
+	uploadSubrouter.Use(middleware.WithUser)
+	uploadSubrouter.HandleFunc("/uploads", CreateUploadFromForm).Methods("POST").HeadersRegexp("Content-Type", "^multipart/form-data")
+	uploadSubrouter.HandleFunc("/uploads/{uploadID}", PutUpload).Methods("PUT")
 
 	////
 	// JSON endpoints
@@ -62,6 +99,11 @@ func New() http.Handler {
 	txEventSubrouter.HandleFunc("/events/{eventID}/rsvps", RemoveRSVPFromEvent).Methods("DELETE")
 	txEventSubrouter.HandleFunc("/events/{eventID}/magic", MagicInvite).Methods("POST")
 	txEventSubrouter.HandleFunc("/events/{eventID}/magic", RollMagicLink).Methods("DELETE")
+	txEventSubrouter.HandleFunc("/events/{eventID}/messages", AddMessageToEvent).Methods("POST")
+	txEventSubrouter.HandleFunc("/events/{eventID}/messages/{messageID}", EditEventMessage).Methods("PATCH")
+	txEventSubrouter.HandleFunc("/events/{eventID}/messages/{messageID}", DeleteEventMessage).Methods("DELETE")
+	txEventSubrouter.HandleFunc("/events/{eventID}/messages/{messageID}/reactions", AddReactionToEventMessage).Methods("POST")
+	txEventSubrouter.HandleFunc("/events/{eventID}/messages/{messageID}/reactions", RemoveReactionFromEventMessage).Methods("DELETE")
 	// Threads
 	txThreadSubrouter := txSubrouter.NewRoute().Subrouter()
 	txThreadSubrouter.Use(middleware.WithUser, middleware.WithThread)
@@ -69,7 +111,10 @@ func New() http.Handler {
 	txThreadSubrouter.HandleFunc("/threads/{threadID}/users/{userID}", AddUserToThread).Methods("POST")
 	txThreadSubrouter.HandleFunc("/threads/{threadID}/users/{userID}", RemoveUserFromThread).Methods("DELETE")
 	txThreadSubrouter.HandleFunc("/threads/{threadID}/messages", AddMessageToThread).Methods("POST")
+	txThreadSubrouter.HandleFunc("/threads/{threadID}/messages/{messageID}", EditThreadMessage).Methods("PATCH")
 	txThreadSubrouter.HandleFunc("/threads/{threadID}/messages/{messageID}", DeleteThreadMessage).Methods("DELETE")
+	txThreadSubrouter.HandleFunc("/threads/{threadID}/messages/{messageID}/reactions", AddReactionToThreadMessage).Methods("POST")
+	txThreadSubrouter.HandleFunc("/threads/{threadID}/messages/{messageID}/reactions", RemoveReactionFromThreadMessage).Methods("DELETE")
 
 	////
 	// JSON & Auth endpoints
@@ -85,6 +130,7 @@ func New() http.Handler {
 	authSubrouter.HandleFunc("/users/emails", MakeEmailPrimary).Methods("PATCH")
 	authSubrouter.HandleFunc("/users/resend", SendVerifyEmail).Methods("POST")
 	authSubrouter.HandleFunc("/users/search", UserSearch).Methods("GET")
+	authSubrouter.HandleFunc("/users/unread", GetUnreadCounts).Methods("GET")
 	authSubrouter.HandleFunc("/users/avatar", PutAvatar).Methods("POST")
 	authSubrouter.HandleFunc("/users/{userID}", GetUser).Methods("GET")
 	// Threads
@@ -97,6 +143,9 @@ func New() http.Handler {
 	authSubrouter.HandleFunc("/contacts", GetContacts).Methods("GET")
 	authSubrouter.HandleFunc("/contacts/{userID}", AddContact).Methods("POST")
 	authSubrouter.HandleFunc("/contacts/{userID}", RemoveContact).Methods("DELETE")
+	// Uploads
+	authSubrouter.HandleFunc("/uploads", CreateUpload).Methods("POST")
+	authSubrouter.HandleFunc("/uploads/{uploadID}", GetUpload).Methods("GET")
 
 	////
 	// JSON & Auth & Thread endpoints
@@ -118,11 +167,23 @@ func New() http.Handler {
 	eventSubrouter.HandleFunc("/events/{eventID}", GetEvent).Methods("GET")
 	eventSubrouter.HandleFunc("/events/{eventID}", DeleteEvent).Methods("DELETE")
 	eventSubrouter.HandleFunc("/events/{eventID}/messages", GetMessagesByEvent).Methods("GET")
-	eventSubrouter.HandleFunc("/events/{eventID}/messages", AddMessageToEvent).Methods("POST")
-	eventSubrouter.HandleFunc("/events/{eventID}/messages/{messageID}", DeleteEventMessage).Methods("DELETE")
 	eventSubrouter.HandleFunc("/events/{eventID}/reads", MarkEventAsRead).Methods("POST")
 	eventSubrouter.HandleFunc("/events/{eventID}/magic", GetMagicLink).Methods("GET")
 
+	////
+	// JSON & Auth & Admin endpoints
+	////
+
+	adminSubrouter := authSubrouter.NewRoute().Subrouter()
+	adminSubrouter.Use(middleware.WithAdmin)
+	adminSubrouter.HandleFunc("/admin/emails", GetSentEmails).Methods("GET")
+	adminSubrouter.HandleFunc("/admin/emails/{emailID}", GetSentEmail).Methods("GET")
+	adminSubrouter.HandleFunc("/admin/emails/preview/{kind}", PreviewEmail).Methods("GET")
+	adminSubrouter.HandleFunc("/admin/migrations/reads", MigrateReads).Methods("POST")
+	adminSubrouter.HandleFunc("/admin/migrations/events", MigrateEventTimestamps).Methods("POST")
+	adminSubrouter.HandleFunc("/admin/migrations/digests", MigrateDigestSchedules).Methods("POST")
+	adminSubrouter.HandleFunc("/admin/blobs/orphans", GetOrphanedBlobs).Methods("GET")
+
 	return middleware.WithLogging(middleware.WithCORS(router))
 }
 
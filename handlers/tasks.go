@@ -3,6 +3,8 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"cloud.google.com/go/datastore"
 	"google.golang.org/api/iterator"
@@ -15,6 +17,13 @@ import (
 	"github.com/hiconvo/api/utils/bjson"
 )
 
+// CreateDigest Endpoint: GET /tasks/digest
+//
+// CreateDigest runs every hour. It finds the users whose digest is due by
+// their NextDigestAt, which is worked out from the hour they picked in their
+// time zone, and queues a task to send each of them their digest, so that a
+// slow or failing user doesn't hold up everyone else. Users whose digest
+// wasn't sent stay due and are picked up again the next hour.
 func CreateDigest(w http.ResponseWriter, r *http.Request) {
 	op := errors.Op("handlers.CreateDigest")
 
@@ -26,7 +35,11 @@ func CreateDigest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
-	query := datastore.NewQuery("User")
+	now := time.Now()
+	// Users who don't get digests have no NextDigestAt
+	query := datastore.NewQuery("User").
+		Filter("NextDigestAt >", time.Time{}).
+		Filter("NextDigestAt <=", now)
 	iter := db.DefaultClient.Run(ctx, query)
 
 	for {
@@ -40,8 +53,12 @@ func CreateDigest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := user.SendDigest(ctx); err != nil {
-			log.Alarm(errors.E(op, errors.Errorf("could not send digest for user='%v': %v", user.ID, err)))
+		if err := queue.PutEmail(ctx, queue.EmailPayload{
+			Type:   queue.User,
+			Action: queue.SendDigest,
+			IDs:    []string{user.ID},
+		}); err != nil {
+			log.Alarm(errors.E(op, errors.Errorf("could not queue digest for user='%v': %v", user.ID, err)))
 		}
 	}
 
@@ -81,6 +98,10 @@ func SendEmailsAsync(w http.ResponseWriter, r *http.Request) {
 
 			if payload.Action == queue.SendWelcome {
 				u.Welcome(ctx)
+			} else if payload.Action == queue.SendDigest {
+				if err := u.SendDigest(ctx); err != nil {
+					log.Alarm(errors.E(op, errors.Errorf("could not send digest for user='%v': %v", u.ID, err)))
+				}
 			}
 		case queue.Event:
 			e, err := models.GetEventByID(ctx, payload.IDs[i])
@@ -90,9 +111,15 @@ func SendEmailsAsync(w http.ResponseWriter, r *http.Request) {
 			}
 
 			if payload.Action == queue.SendInvites {
-				e.SendInvites(ctx)
+				err = e.SendInvites(ctx)
 			} else if payload.Action == queue.SendUpdatedInvites {
-				e.SendUpdatedInvites(ctx)
+				err = e.SendUpdatedInvites(ctx)
+			} else if payload.Action == queue.SendMentions {
+				err = e.SendMentions(ctx)
+			}
+
+			if err != nil {
+				log.Alarm(errors.E(op, err))
 			}
 		case queue.Thread:
 			t, err := models.GetThreadByID(ctx, payload.IDs[i])
@@ -111,3 +138,32 @@ func SendEmailsAsync(w http.ResponseWriter, r *http.Request) {
 
 	bjson.WriteJSON(w, map[string]string{"message": "pass"}, http.StatusOK)
 }
+
+// CollectGarbage Endpoint: GET /tasks/gc?dryRun=true
+//
+// CollectGarbage runs every day. It deletes the photos, attachments, avatars
+// and uploads in storage that nothing refers to anymore, such as those of
+// deleted threads and replaced avatars. With dryRun, it only reports them.
+func CollectGarbage(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.CollectGarbage")
+
+	if val := r.Header.Get("X-Appengine-Cron"); val != "true" {
+		bjson.WriteJSON(w, map[string]string{
+			"message": "Not found",
+		}, http.StatusNotFound)
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	report, err := models.CollectBlobGarbage(r.Context(), dryRun)
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	log.Printf("%s: found %d orphaned files (%d bytes) out of %d, %d could not be deleted, dryRun=%v",
+		op, len(report.Orphans), report.Bytes, report.Scanned, report.Failed, dryRun)
+
+	bjson.WriteJSON(w, report, http.StatusOK)
+}
@@ -66,6 +66,13 @@ func CreateThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Since an email is sent when a thread is created,
+	// it is initialized as having been read by all members.
+	if err := models.MarkAsRead(ctx, &thread, thread.UserKeys...); err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
 	bjson.WriteJSON(w, thread, http.StatusCreated)
 }
 
@@ -83,7 +90,15 @@ func GetThreads(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bjson.WriteJSON(w, map[string][]*models.Thread{"threads": threads}, http.StatusOK)
+	if err := models.SignThreadPreviews(ctx, threads); err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
+	bjson.WriteJSON(w, map[string]interface{}{
+		"threads":    threads,
+		"nextCursor": p.Next,
+	}, http.StatusOK)
 }
 
 // GetThread Endpoint: GET /threads/{id}
@@ -95,6 +110,11 @@ func GetThread(w http.ResponseWriter, r *http.Request) {
 	thread := middleware.ThreadFromContext(ctx)
 
 	if thread.OwnerIs(&u) || thread.HasUser(&u) {
+		if err := models.SignThreadPreviews(ctx, []*models.Thread{&thread}); err != nil {
+			bjson.HandleError(w, err)
+			return
+		}
+
 		bjson.WriteJSON(w, thread, http.StatusOK)
 		return
 	}
@@ -147,6 +167,12 @@ func UpdateThread(w http.ResponseWriter, r *http.Request) {
 		bjson.HandleError(w, err)
 		return
 	}
+
+	if err := models.SignThreadPreviews(ctx, []*models.Thread{&thread}); err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
 	bjson.WriteJSON(w, thread, http.StatusOK)
 }
 
@@ -167,11 +193,21 @@ func DeleteThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	messages, err := models.GetMessagesByThread(ctx, &thread, &models.Pagination{Size: -1})
+	if err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
 	if err := thread.Delete(ctx); err != nil {
 		bjson.HandleError(w, err)
 		return
 	}
 
+	// The files that were sent to the thread no longer count toward the
+	// storage used by their senders
+	models.ReleaseStorage(ctx, messages)
+
 	bjson.WriteJSON(w, thread, http.StatusOK)
 }
 
@@ -225,6 +261,11 @@ func AddUserToThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := models.SignThreadPreviews(ctx, []*models.Thread{&thread}); err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
 	bjson.WriteJSON(w, thread, http.StatusOK)
 }
 
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	htmltpl "html/template"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/log"
+	"github.com/hiconvo/api/models"
+	"github.com/hiconvo/api/utils/magic"
+)
+
+var unsubscribeTpl = htmltpl.Must(htmltpl.New("unsubscribe").Parse(`<!doctype html>
+<html>
+  <head>
+    <meta name="viewport" content="width=device-width, initial-scale=1" />
+    <title>Unsubscribe from Convo</title>
+    <style>
+      body { font-family: sans-serif; color: #222222; margin: 60px auto; max-width: 480px; padding: 0 20px; }
+      button { background: #3498db; border: 0; border-radius: 5px; color: #ffffff; cursor: pointer; font-size: 14px; padding: 12px 25px; }
+    </style>
+  </head>
+  <body>
+    {{ if .Done }}
+      <p>You have been unsubscribed from {{ .Description }}. It may take a moment for this to take effect.</p>
+    {{ else }}
+      <p>Stop receiving {{ .Description }}?</p>
+      <form method="post">
+        <button type="submit">Unsubscribe</button>
+      </form>
+    {{ end }}
+  </body>
+</html>
+`))
+
+var unsubscribeDescriptions = map[string]string{
+	models.EmailCategoryDigest:  "digest emails",
+	models.EmailCategoryThreads: "emails about new messages",
+	models.EmailCategoryEvents:  "event invitations and updates",
+}
+
+// Unsubscribe Endpoint: GET, POST /unsubscribe/{category}/{userID}/{signature}
+//
+// Unsubscribe opts a user out of a category of emails. It is linked to from
+// every email and doesn't require authentication since the link is signed.
+// GET requests render a confirmation page so that link scanners don't
+// unsubscribe anyone. POST requests record the opt-out. These are sent either
+// by the confirmation page or directly by mail clients that support RFC 8058
+// one-click unsubscribe.
+func Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.Unsubscribe")
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	category := vars["category"]
+	userID := vars["userID"]
+
+	if !models.IsEmailCategory(category) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := magic.VerifyUnsubscribe(userID, category, vars["signature"]); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		Description string
+		Done        bool
+	}{
+		Description: unsubscribeDescriptions[category],
+		Done:        r.Method == http.MethodPost,
+	}
+
+	if data.Done {
+		u, err := models.GetUserByID(ctx, userID)
+		if err != nil {
+			log.Alarm(errors.E(op, err))
+			http.NotFound(w, r)
+			return
+		}
+
+		u.Unsubscribe(category)
+
+		if err := u.Commit(ctx); err != nil {
+			log.Alarm(errors.E(op, err))
+			http.Error(w, "Something went wrong. Please try again.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := unsubscribeTpl.Execute(w, data); err != nil {
+		log.Alarm(errors.E(op, err))
+	}
+}
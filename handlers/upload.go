@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/middleware"
+	"github.com/hiconvo/api/models"
+	"github.com/hiconvo/api/utils/bjson"
+	"github.com/hiconvo/api/utils/validate"
+)
+
+// CreateUpload Endpoint: POST /uploads
+//
+// Request payload:
+type createUploadPayload struct {
+	Name string  `validate:"nonzero,max=255"`
+	Type string  `validate:"nonzero"`
+	Size float64 `validate:"nonzero"`
+}
+
+// CreateUpload requests an upload of a file with the given name, content
+// type, and size. The file is then sent to PUT /uploads/{id}, whole or in
+// parts.
+func CreateUpload(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.CreateUpload")
+	ctx := r.Context()
+	u := middleware.UserFromContext(ctx)
+	body := bjson.BodyFromContext(ctx)
+
+	var payload createUploadPayload
+	if err := validate.Do(&payload, body); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	upload, err := models.NewUpload(&u, payload.Name, payload.Type, int(payload.Size))
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if err := upload.Commit(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, upload, http.StatusCreated)
+}
+
+// CreateUploadFromForm Endpoint: POST /uploads
+//
+// Request payload: multipart/form-data with the file in a part named "file"
+
+// CreateUploadFromForm saves the file in the given form in one go. The file
+// is streamed to storage as it is read, so it is never held in memory.
+func CreateUploadFromForm(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.CreateUploadFromForm")
+	ctx := r.Context()
+	u := middleware.UserFromContext(ctx)
+
+	// Leave room for the other parts of the form
+	r.Body = http.MaxBytesReader(w, r.Body, models.MaxUploadSize+1<<20)
+
+	form, err := r.MultipartReader()
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err, http.StatusBadRequest))
+		return
+	}
+
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			bjson.HandleError(w, errors.E(op, errors.Str("NoFile"),
+				map[string]string{"file": "This field is required"},
+				http.StatusBadRequest))
+			return
+		} else if err != nil {
+			bjson.HandleError(w, errors.E(op, err, http.StatusBadRequest))
+			return
+		}
+
+		if part.FormName() != "file" {
+			continue
+		}
+
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		upload, err := models.NewUpload(&u, part.FileName(), contentType, 0)
+		if err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+
+		if err := upload.Put(ctx, part); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+
+		if err := upload.Commit(ctx); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+
+		bjson.WriteJSON(w, upload, http.StatusCreated)
+		return
+	}
+}
+
+// GetUpload Endpoint: GET /uploads/{id}
+
+// GetUpload gets the given upload. Received says where an interrupted
+// upload continues from.
+func GetUpload(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.GetUpload")
+	ctx := r.Context()
+	u := middleware.UserFromContext(ctx)
+
+	upload, err := models.GetUploadByID(ctx, mux.Vars(r)["uploadID"])
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if !upload.OwnerIs(&u) {
+		bjson.HandleError(w, errors.E(op, errors.Str("NoPermission"), http.StatusNotFound))
+		return
+	}
+
+	bjson.WriteJSON(w, upload, http.StatusOK)
+}
+
+// PutUpload Endpoint: PUT /uploads/{id}
+//
+// Request payload: the raw bytes of the file, or of the part of it given in
+// the Content-Range header
+
+// PutUpload saves the body of the request to the given upload. Without a
+// Content-Range header, the body is the whole file. With one, it is the next
+// part, and the upload is complete once all of its parts are received.
+func PutUpload(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.PutUpload")
+	ctx := r.Context()
+	u := middleware.UserFromContext(ctx)
+
+	upload, err := models.GetUploadByID(ctx, mux.Vars(r)["uploadID"])
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if !upload.OwnerIs(&u) {
+		bjson.HandleError(w, errors.E(op, errors.Str("NoPermission"), http.StatusNotFound))
+		return
+	}
+
+	if contentRange := r.Header.Get("Content-Range"); contentRange == "" {
+		err = upload.Put(ctx, r.Body)
+	} else {
+		var start, end, total int
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err != nil ||
+			start > end || end >= total || total != upload.Size {
+			bjson.HandleError(w, errors.E(op, errors.Str("InvalidContentRange"),
+				map[string]string{"upload": "Invalid Content-Range"},
+				http.StatusBadRequest))
+			return
+		}
+
+		if start == 0 && end == total-1 {
+			err = upload.Put(ctx, r.Body)
+		} else {
+			err = upload.PutPart(ctx, r.Body, start, end)
+		}
+	}
+	if err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	if err := upload.Commit(ctx); err != nil {
+		bjson.HandleError(w, errors.E(op, err))
+		return
+	}
+
+	bjson.WriteJSON(w, upload, http.StatusOK)
+}
@@ -9,10 +9,12 @@ import (
 
 	"github.com/hiconvo/api/errors"
 	"github.com/hiconvo/api/log"
+	"github.com/hiconvo/api/mail"
 	"github.com/hiconvo/api/middleware"
 	"github.com/hiconvo/api/models"
 	"github.com/hiconvo/api/storage"
 	"github.com/hiconvo/api/utils/bjson"
+	"github.com/hiconvo/api/utils/i18n"
 	"github.com/hiconvo/api/utils/magic"
 	"github.com/hiconvo/api/utils/oauth"
 	"github.com/hiconvo/api/utils/validate"
@@ -85,6 +87,9 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Send emails in the language of the user's browser until they pick one
+	user.Locale = i18n.Negotiate(r.Header.Get("Accept-Language"))
+
 	// Save the user object
 	if err := user.Commit(ctx); err != nil {
 		bjson.HandleError(w, err)
@@ -312,6 +317,8 @@ func OAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	u.Locale = i18n.Negotiate(r.Header.Get("Accept-Language"))
+
 	// Save the user and create the welcome convo.
 	if err := u.Commit(ctx); err != nil {
 		bjson.HandleError(w, err)
@@ -326,14 +333,25 @@ func OAuth(w http.ResponseWriter, r *http.Request) {
 //
 // Request payload:
 type updateUserPayload struct {
-	FirstName string
-	LastName  string
-	Password  bool
+	FirstName              string
+	LastName               string
+	Password               bool
+	NotificationPreference string
+	Unsubscribed           []interface{}
+	Locale                 string
+	Timezone               string
+	DigestHour             float64
+	HideReadReceipts       bool
 }
 
 // UpdateUser is an endpoint that can do three things. It can
 //   - update FirstName and LastName fields on a user
 //   - initiate a password update which requires email based validation
+//   - change which emails the user receives about new activity
+//   - replace the categories of email the user is unsubscribed from
+//   - change the language emails are sent in
+//   - change the time zone and hour of day the digest is sent at
+//   - hide or show the user's read receipts on messages
 //
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -346,10 +364,84 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if payload.NotificationPreference != "" && !models.IsNotificationPreference(payload.NotificationPreference) {
+		bjson.HandleError(w, errors.E(
+			errors.Op("handlers.UpdateUser"),
+			map[string]string{"notificationPreference": "Nope"},
+			http.StatusBadRequest))
+		return
+	}
+
+	if payload.Locale != "" && !i18n.IsSupported(payload.Locale) {
+		bjson.HandleError(w, errors.E(
+			errors.Op("handlers.UpdateUser"),
+			map[string]string{"locale": "Nope"},
+			http.StatusBadRequest))
+		return
+	}
+
+	if payload.Timezone != "" && !models.IsTimezone(payload.Timezone) {
+		bjson.HandleError(w, errors.E(
+			errors.Op("handlers.UpdateUser"),
+			map[string]string{"timezone": "Nope"},
+			http.StatusBadRequest))
+		return
+	}
+
+	// Zero is a valid hour, so check whether the field was given at all
+	hasDigestHour := body["digestHour"] != nil
+	digestHour := int(payload.DigestHour)
+	if hasDigestHour && (float64(digestHour) != payload.DigestHour || !models.IsDigestHour(digestHour)) {
+		bjson.HandleError(w, errors.E(
+			errors.Op("handlers.UpdateUser"),
+			map[string]string{"digestHour": "Nope"},
+			http.StatusBadRequest))
+		return
+	}
+
+	var unsubscribed []string
+	for _, v := range payload.Unsubscribed {
+		category, ok := v.(string)
+		if !ok || !models.IsEmailCategory(category) {
+			bjson.HandleError(w, errors.E(
+				errors.Op("handlers.UpdateUser"),
+				map[string]string{"unsubscribed": "Nope"},
+				http.StatusBadRequest))
+			return
+		}
+
+		unsubscribed = append(unsubscribed, category)
+	}
+
 	if payload.Password {
 		u.SendPasswordResetEmail()
 	}
 
+	if payload.NotificationPreference != "" {
+		u.NotificationPreference = payload.NotificationPreference
+	}
+
+	if payload.Unsubscribed != nil {
+		u.Unsubscribed = unsubscribed
+	}
+
+	if payload.Locale != "" {
+		u.Locale = payload.Locale
+	}
+
+	if payload.Timezone != "" {
+		u.Timezone = payload.Timezone
+	}
+
+	if hasDigestHour {
+		u.DigestHour = digestHour
+	}
+
+	// False is meaningful too, so check whether the field was given at all
+	if body["hideReadReceipts"] != nil {
+		u.HideReadReceipts = payload.HideReadReceipts
+	}
+
 	// TODO: Come up with something better than this.
 	if payload.FirstName != "" && payload.FirstName != u.FirstName {
 		u.FirstName = payload.FirstName
@@ -485,8 +577,15 @@ func VerifyEmail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	u.AddEmail(femail)
+	// Receiving the verification email proves that the address works
+	u.ClearBouncing(femail)
 	u.DeriveProperties()
 
+	if err := mail.Unsuppress(ctx, femail); err != nil {
+		bjson.HandleError(w, err)
+		return
+	}
+
 	if u.Email == femail {
 		u.IsLocked = false
 	}
@@ -534,9 +633,19 @@ func ForgotPassword(w http.ResponseWriter, r *http.Request) {
 
 // SendVerifyEmail Endpoint: POST /users/resend
 
-// SendVerifyEmail resends the email verification email.
+// SendVerifyEmail resends the email verification email. If the address was
+// suppressed because it bounced, it is given another chance.
 func SendVerifyEmail(w http.ResponseWriter, r *http.Request) {
-	u := middleware.UserFromContext(r.Context())
+	ctx := r.Context()
+	u := middleware.UserFromContext(ctx)
+
+	if u.IsEmailBouncing {
+		if err := mail.Unsuppress(ctx, u.Email); err != nil {
+			bjson.HandleError(w, err)
+			return
+		}
+	}
+
 	err := u.SendVerifyEmail(u.Email)
 	if err != nil {
 		bjson.HandleError(w, err)
@@ -678,14 +787,16 @@ func UserSearch(w http.ResponseWriter, r *http.Request) {
 //
 // Request payload:
 type putAvatarPayload struct {
-	Blob string `validate:"nonzero"`
-	X    float64
-	Y    float64
-	Size float64
+	Blob   string
+	Upload string
+	X      float64
+	Y      float64
+	Size   float64
 }
 
 // PutAvatar sets the user's avatar to the one given
 func PutAvatar(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.PutAvatar")
 	ctx := r.Context()
 	u := middleware.UserFromContext(ctx)
 	body := bjson.BodyFromContext(ctx)
@@ -696,15 +807,54 @@ func PutAvatar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	avatarURL, err := storage.DefaultClient.PutAvatarFromBlob(
-		ctx,
-		payload.Blob,
-		int(payload.Size),
-		int(payload.X),
-		int(payload.Y),
-		storage.DefaultClient.GetKeyFromAvatarURL(u.Avatar))
-	if err != nil {
-		bjson.HandleError(w, err)
+	oldKey := storage.DefaultClient.GetKeyFromAvatarURL(u.Avatar)
+
+	var avatarURL string
+	var uploads []*models.Upload
+	if payload.Upload != "" {
+		upload, err := models.GetCompleteUpload(ctx, &u, payload.Upload, "upload")
+		if err != nil {
+			bjson.HandleError(w, err)
+			return
+		}
+
+		if !upload.IsPhoto() {
+			bjson.HandleError(w, errors.E(op, errors.Str("NotPhoto"),
+				map[string]string{"upload": "Avatars must be images"},
+				http.StatusBadRequest))
+			return
+		}
+
+		avatarURL, err = storage.DefaultClient.PutAvatarFromUpload(
+			ctx,
+			upload.BlobKey,
+			int(payload.Size),
+			int(payload.X),
+			int(payload.Y),
+			oldKey)
+		if err != nil {
+			bjson.HandleError(w, err)
+			return
+		}
+
+		uploads = append(uploads, upload)
+	} else if payload.Blob != "" {
+		var err error
+		avatarURL, err = storage.DefaultClient.PutAvatarFromBlob(
+			ctx,
+			payload.Blob,
+			int(payload.Size),
+			int(payload.X),
+			int(payload.Y),
+			oldKey)
+		if err != nil {
+			bjson.HandleError(w, err)
+			return
+		}
+	} else {
+		bjson.HandleError(w, errors.E(op, errors.Str("NoImage"),
+			map[string]string{"blob": "This field is required"},
+			http.StatusBadRequest))
 		return
 	}
 
@@ -714,6 +864,8 @@ func PutAvatar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	models.DeleteUploads(ctx, uploads)
+
 	bjson.WriteJSON(w, u, http.StatusOK)
 }
 
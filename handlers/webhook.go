@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/log"
+	"github.com/hiconvo/api/mail"
+	"github.com/hiconvo/api/models"
+	"github.com/hiconvo/api/utils/bjson"
+	"github.com/hiconvo/api/utils/secrets"
+)
+
+var emailWebhookToken = secrets.Get("EMAIL_WEBHOOK_TOKEN", "")
+
+// emailEvent is the subset of a SendGrid event webhook payload that we care
+// about. SendGrid posts a JSON array of these.
+type emailEvent struct {
+	Email  string `json:"email"`
+	Event  string `json:"event"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// EmailEvents Endpoint: POST /webhooks/email?token={token}
+//
+// EmailEvents ingests delivery events from the email provider. Addresses
+// that bounce, are dropped because they bounced before or are invalid, or
+// report our mail as spam are added to the suppression list so that
+// mail.Send stops emailing them. Bounced and dropped addresses are also
+// flagged on their users so that they can be told that their email is
+// bouncing.
+func EmailEvents(w http.ResponseWriter, r *http.Request) {
+	op := errors.Op("handlers.EmailEvents")
+	ctx := r.Context()
+
+	token := r.URL.Query().Get("token")
+	if emailWebhookToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(emailWebhookToken)) != 1 {
+		notFound(w, r)
+		return
+	}
+
+	var events []emailEvent
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		bjson.HandleError(w, errors.E(op, http.StatusBadRequest, err,
+			map[string]string{"message": "Could not decode JSON"}))
+		return
+	}
+
+	for _, ev := range events {
+		var isBounce bool
+		switch ev.Event {
+		case "bounce":
+			// SendGrid reports temporary rejections as bounces of type
+			// "blocked". Those addresses may well work later.
+			if ev.Type == "blocked" {
+				continue
+			}
+			isBounce = true
+		case "dropped":
+			// Messages are also dropped for reasons that have nothing to
+			// do with the address, such as a malformed request
+			if !isBouncedDrop(ev.Reason) {
+				continue
+			}
+			isBounce = true
+		case "spamreport":
+			isBounce = false
+		default:
+			continue
+		}
+
+		if err := mail.Suppress(ctx, ev.Email, ev.Event+": "+ev.Reason); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+
+		if !isBounce {
+			continue
+		}
+
+		u, found, err := models.GetUserByEmail(ctx, ev.Email)
+		if err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		} else if !found {
+			continue
+		}
+
+		u.MarkBouncing(ev.Email)
+
+		if err := u.Commit(ctx); err != nil {
+			bjson.HandleError(w, errors.E(op, err))
+			return
+		}
+
+		log.Printf("handlers.EmailEvents: flagged %s as bouncing", ev.Email)
+	}
+
+	bjson.WriteJSON(w, map[string]string{"message": "pass"}, http.StatusOK)
+}
+
+// isBouncedDrop returns true if SendGrid gave the reason for dropping a
+// message as the address having bounced before or being invalid.
+func isBouncedDrop(reason string) bool {
+	reason = strings.ToLower(strings.TrimSpace(reason))
+	return strings.HasPrefix(reason, "bounced address") ||
+		reason == "invalid" ||
+		strings.HasPrefix(reason, "invalid address") ||
+		strings.HasPrefix(reason, "invalid email")
+}
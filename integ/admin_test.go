@@ -0,0 +1,128 @@
+package router_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hiconvo/api/utils/thelpers"
+)
+
+////////////////////////////
+// GET /admin/emails Tests
+////////////////////////////
+
+func TestGetSentEmails(t *testing.T) {
+	user, _ := createTestUser(t)
+	admin, _ := createTestUser(t)
+	admin.IsAdmin = true
+	if err := admin.Commit(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	_, rr, _ := thelpers.TestEndpoint(t, tc, th, "POST", "/users/forgot", map[string]interface{}{"email": user.Email}, nil)
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+
+	_, rr, _ = thelpers.TestEndpoint(t, tc, th, "GET", "/admin/emails", nil, getAuthHeader(user.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusNotFound)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "GET", "/admin/emails", nil, getAuthHeader(admin.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+
+	emails, ok := respData["emails"].([]interface{})
+	if !ok || len(emails) == 0 {
+		t.Fatalf("expected at least one email, got %v", respData["emails"])
+	}
+
+	latest := emails[0].(map[string]interface{})
+	thelpers.AssertEqual(t, latest["toEmail"], user.Email)
+	thelpers.AssertEqual(t, latest["status"], "sent")
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", fmt.Sprintf("/admin/emails/%s", latest["id"]), nil, getAuthHeader(admin.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["subject"], latest["subject"])
+
+	// The magic link to reset the password isn't kept
+	if html, _ := respData["htmlContent"].(string); !strings.Contains(html, "[redacted]") || strings.Contains(html, user.ID) {
+		t.Errorf("expected the link in the email to be redacted, got %q", html)
+	}
+
+	_, rr, _ = thelpers.TestEndpoint(t, tc, th, "GET", fmt.Sprintf("/admin/emails/%s", latest["id"]), nil, getAuthHeader(user.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusNotFound)
+}
+
+////////////////////////////////////////
+// GET /admin/emails/preview/{kind} Tests
+////////////////////////////////////////
+
+func TestPreviewEmail(t *testing.T) {
+	user, _ := createTestUser(t)
+	admin, _ := createTestUser(t)
+	admin.IsAdmin = true
+	if err := admin.Commit(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	type test struct {
+		URL          string
+		Token        string
+		ExpectStatus int
+		ExpectBody   string
+	}
+
+	tests := []test{
+		{URL: "/admin/emails/preview/thread", Token: user.Token, ExpectStatus: http.StatusNotFound},
+		{URL: "/admin/emails/preview/thread", Token: admin.Token, ExpectStatus: http.StatusOK, ExpectBody: "<title>Dinner on Saturday</title>"},
+		{URL: "/admin/emails/preview/event?locale=es", Token: admin.Token, ExpectStatus: http.StatusOK, ExpectBody: "Confirmar asistencia"},
+		{URL: "/admin/emails/preview/digest?format=text", Token: admin.Token, ExpectStatus: http.StatusOK, ExpectBody: "You have notifications on Convo."},
+		{URL: "/admin/emails/preview/nope", Token: admin.Token, ExpectStatus: http.StatusNotFound},
+	}
+
+	for _, testCase := range tests {
+		req, err := http.NewRequest("GET", testCase.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Authorization", "Bearer "+testCase.Token)
+
+		rr := httptest.NewRecorder()
+		th.ServeHTTP(rr, req)
+
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectStatus)
+
+		if testCase.ExpectBody != "" && !strings.Contains(rr.Body.String(), testCase.ExpectBody) {
+			t.Errorf("expected response to contain %q", testCase.ExpectBody)
+		}
+	}
+}
+
+//////////////////////////////////
+// GET /admin/blobs/orphans Tests
+//////////////////////////////////
+
+func TestGetOrphanedBlobs(t *testing.T) {
+	user, _ := createTestUser(t)
+	admin, _ := createTestUser(t)
+	admin.IsAdmin = true
+	if err := admin.Commit(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	_, rr, _ := thelpers.TestEndpoint(t, tc, th, "GET", "/admin/blobs/orphans", nil, getAuthHeader(user.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusNotFound)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "GET", "/admin/blobs/orphans", nil, getAuthHeader(admin.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["dryRun"], true)
+	thelpers.AssertEqual(t, respData["failed"], float64(0))
+
+	if _, ok := respData["orphans"].([]interface{}); !ok {
+		t.Fatalf("expected a list of orphans, got %v", respData["orphans"])
+	}
+
+	// The task itself only runs from cron
+	_, rr, _ = thelpers.TestEndpoint(t, tc, th, "GET", "/tasks/gc", nil, nil)
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusNotFound)
+}
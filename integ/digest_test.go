@@ -0,0 +1,152 @@
+package router_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hiconvo/api/models"
+	"github.com/hiconvo/api/utils/thelpers"
+)
+
+func TestSendDigest(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member})
+	quietThread := createTestThread(t, &owner, []*models.User{&member})
+
+	var messages []models.Message
+	for i := 0; i < 7; i++ {
+		messages = append(messages, createTestThreadMessage(t, &owner, &thread))
+	}
+
+	// The member's own messages are never digested
+	createTestThreadMessage(t, &member, &quietThread)
+
+	since := member.GetDigestSince(time.Now())
+
+	digestables, err := models.GetDigestablesByUser(tc, &member, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digestList, err := models.GenerateDigestList(tc, digestables, &member, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the five most recent messages are included, oldest first
+	thelpers.AssertEqual(t, len(digestList), 1)
+	thelpers.AssertEqual(t, digestList[0].Name, thread.Subject)
+	thelpers.AssertEqual(t, len(digestList[0].Messages), 5)
+	for i, m := range digestList[0].Messages {
+		thelpers.AssertEqual(t, m.ID, messages[i+2].ID)
+		thelpers.AssertEqual(t, m.User.ID, owner.ID)
+	}
+
+	// Make the member's digest due this hour
+	member.Timezone = "UTC"
+	member.DigestHour = time.Now().UTC().Hour()
+	if err := member.Commit(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := member.SendDigest(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshedMember, err := models.GetUserByID(tc, member.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if refreshedMember.LastDigestAt.IsZero() {
+		t.Fatal("expected LastDigestAt to be set after sending a digest")
+	}
+
+	// The next digest is scheduled after the hour the last one was sent in
+	if !refreshedMember.NextDigestAt.After(refreshedMember.LastDigestAt) {
+		t.Fatalf("expected NextDigestAt to be after %v, got %v", refreshedMember.LastDigestAt, refreshedMember.NextDigestAt)
+	}
+
+	// Nothing new has happened since, so there is nothing left to digest
+	digestables, err = models.GetDigestablesByUser(tc, &refreshedMember, refreshedMember.GetDigestSince(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thelpers.AssertEqual(t, len(digestables), 0)
+}
+
+func TestSendDigestSkipsUsersWhoAreNotDue(t *testing.T) {
+	member, _ := createTestUser(t)
+	member.Timezone = "UTC"
+	member.DigestHour = (time.Now().UTC().Hour() + 2) % 24
+	if err := member.Commit(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := member.SendDigest(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshedMember, err := models.GetUserByID(tc, member.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !refreshedMember.LastDigestAt.IsZero() {
+		t.Fatalf("expected no digest to be sent, got LastDigestAt %v", refreshedMember.LastDigestAt)
+	}
+}
+
+// BenchmarkGenerateDigest measures computing a digest for a user who is in
+// many threads and events, only a few of which have had new messages since
+// their last digest.
+func BenchmarkGenerateDigest(b *testing.B) {
+	owner, _ := createTestUser(b)
+	member, _ := createTestUser(b)
+
+	var threads []models.Thread
+	for i := 0; i < 20; i++ {
+		thread := createTestThread(b, &owner, []*models.User{&member})
+		for j := 0; j < 20; j++ {
+			createTestThreadMessage(b, &owner, &thread)
+		}
+		threads = append(threads, thread)
+
+		event := createTestEvent(b, &owner, []*models.User{&member}, []*models.User{})
+		for j := 0; j < 20; j++ {
+			createTestEventMessage(b, &owner, event)
+		}
+
+		if err := event.Commit(tc); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	since := time.Now()
+
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 3; j++ {
+			createTestThreadMessage(b, &owner, &threads[i])
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		digestables, err := models.GetDigestablesByUser(tc, &member, since)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		digestList, err := models.GenerateDigestList(tc, digestables, &member, since)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if len(digestList) != 5 {
+			b.Fatalf("expected 5 digest items, got %d", len(digestList))
+		}
+	}
+}
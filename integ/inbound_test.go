@@ -6,8 +6,11 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/hiconvo/api/mail"
 	"github.com/hiconvo/api/models"
 	"github.com/hiconvo/api/utils/thelpers"
 )
@@ -18,24 +21,124 @@ func TestInboundSucceedsWithValidPayload(t *testing.T) {
 	u3, _ := createTestUser(t)
 	thread := createTestThread(t, &u1, []*models.User{&u2, &u3})
 
-	messages, err := models.GetMessagesByThread(tc, &thread)
+	messages, err := models.GetMessagesByThread(tc, &thread, &models.Pagination{Size: -1})
 	if err != nil {
 		t.Fatal(err)
 	}
 	initalMessageCount := len(messages)
 
+	rr := postInbound(t, thread.GetReplyEmail(&u1), &u1, thread.Subject)
+
+	newMessages, err := models.GetMessagesByThread(tc, &thread, &models.Pagination{Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	finalMessageCount := len(newMessages)
+
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, finalMessageCount > initalMessageCount, true)
+}
+
+func TestInboundRejectsForwardedCopies(t *testing.T) {
+	u1, _ := createTestUser(t)
+	u2, _ := createTestUser(t)
+	u3, _ := createTestUser(t)
+	thread := createTestThread(t, &u1, []*models.User{&u2, &u3})
+
+	messages, err := models.GetMessagesByThread(tc, &thread, &models.Pagination{Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initalMessageCount := len(messages)
+
+	// u2 replies to the copy of the email that was sent to u1
+	rr := postInbound(t, thread.GetReplyEmail(&u1), &u2, thread.Subject)
+
+	newMessages, err := models.GetMessagesByThread(tc, &thread, &models.Pagination{Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, len(newMessages), initalMessageCount)
+}
+
+func TestInboundRejectsForgedAddresses(t *testing.T) {
+	u1, _ := createTestUser(t)
+	u2, _ := createTestUser(t)
+	thread := createTestThread(t, &u1, []*models.User{&u2})
+
+	messages, err := models.GetMessagesByThread(tc, &thread, &models.Pagination{Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initalMessageCount := len(messages)
+
+	for _, to := range []string{
+		fmt.Sprintf("test-%d@mail.convo.events", thread.Key.ID),
+		fmt.Sprintf("test-%s.%s.000000000000@mail.convo.events",
+			strconv.FormatInt(thread.Key.ID, 36), strconv.FormatInt(u1.Key.ID, 36)),
+	} {
+		rr := postInbound(t, to, &u1, thread.Subject)
+		thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	}
+
+	newMessages, err := models.GetMessagesByThread(tc, &thread, &models.Pagination{Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thelpers.AssertEqual(t, len(newMessages), initalMessageCount)
+}
+
+func TestInboundExplainsLegacyAddresses(t *testing.T) {
+	u1, _ := createTestUser(t)
+	u2, _ := createTestUser(t)
+	thread := createTestThread(t, &u1, []*models.User{&u2})
+
+	messages, err := models.GetMessagesByThread(tc, &thread, &models.Pagination{Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initalMessageCount := len(messages)
+
+	// Addresses used to hold only the ID of the thread
+	rr := postInbound(t, fmt.Sprintf("test-%d@mail.convo.events", thread.Key.ID), &u1, thread.Subject)
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+
+	newMessages, err := models.GetMessagesByThread(tc, &thread, &models.Pagination{Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thelpers.AssertEqual(t, len(newMessages), initalMessageCount)
+
+	if mail.DefaultOutbox == nil {
+		t.Skip("EMAIL_OUTBOX is not set")
+	}
+
+	// The sender is told why their reply wasn't posted
+	records, err := mail.DefaultOutbox.GetAll(tc, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thelpers.AssertEqual(t, len(records), 1)
+	thelpers.AssertEqual(t, records[0].ToEmail, u1.Email)
+	thelpers.AssertEqual(t, strings.Contains(records[0].TextContent, "before we changed the addresses"), true)
+}
+
+func postInbound(t *testing.T, to string, from *models.User, subject string) *httptest.ResponseRecorder {
 	var b bytes.Buffer
 	form := multipart.NewWriter(&b)
 
 	form.WriteField("dkim", "{@sendgrid.com : pass}")
-	form.WriteField("to", thread.GetEmail())
+	form.WriteField("to", to)
 	form.WriteField("html", "<html><body><p>Hello, does this work?</p></body></html>")
-	form.WriteField("from", fmt.Sprintf("%s <%s>", u1.FullName, u1.Email))
+	form.WriteField("from", fmt.Sprintf("%s <%s>", from.FullName, from.Email))
 	form.WriteField("text", "Hello, does this work?")
 	form.WriteField("sender_ip", "0.0.0.0")
-	form.WriteField("envelope", fmt.Sprintf(`{"to":["%s"],"from":"%s"}`, thread.GetEmail(), u1.Email))
+	form.WriteField("envelope", fmt.Sprintf(`{"to":["%s"],"from":"%s"}`, to, from.Email))
 	form.WriteField("attachments", "0")
-	form.WriteField("subject", thread.Subject)
+	form.WriteField("subject", subject)
 	form.WriteField("charsets", `{"to":"UTF-8","html":"UTF-8","subject":"UTF-8","from":"UTF-8","text":"UTF-8"}`)
 	form.WriteField("SPF", "pass")
 
@@ -51,14 +154,7 @@ func TestInboundSucceedsWithValidPayload(t *testing.T) {
 	rr := httptest.NewRecorder()
 	th.ServeHTTP(rr, req)
 
-	newMessages, err := models.GetMessagesByThread(tc, &thread)
-	if err != nil {
-		t.Fatal(err)
-	}
-	finalMessageCount := len(newMessages)
-
-	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
-	thelpers.AssertEqual(t, finalMessageCount > initalMessageCount, true)
+	return rr
 }
 
 // func TestInboundFailsWithInvalidPayload(t *testing.T) {
@@ -10,6 +10,7 @@ import (
 	"github.com/steinfletcher/apitest"
 	jsonpath "github.com/steinfletcher/apitest-jsonpath"
 
+	"github.com/hiconvo/api/db"
 	"github.com/hiconvo/api/models"
 	"github.com/hiconvo/api/utils/thelpers"
 )
@@ -94,7 +95,9 @@ func TestAddMessageToThread(t *testing.T) {
 				Assert(jsonpath.Equal("$.user.fullName", testCase.GivenAuthor.FullName)).
 				Assert(jsonpath.Equal("$.user.id", testCase.GivenAuthor.ID))
 			if testCase.ExpectPhoto {
-				tt.Assert(jsonpath.Present("$.photos[0]"))
+				tt.Assert(jsonpath.Present("$.photos[0]")).
+					Assert(jsonpath.Present("$.photoSizes[0].thumbnail")).
+					Assert(jsonpath.Present("$.photoSizes[0].medium"))
 			} else {
 				tt.Assert(jsonpath.NotPresent("$.photos[0]"))
 			}
@@ -104,6 +107,151 @@ func TestAddMessageToThread(t *testing.T) {
 	}
 }
 
+func TestAddMessageToThreadWithAttachments(t *testing.T) {
+	owner, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{})
+	url := fmt.Sprintf("/threads/%s/messages", thread.ID)
+	photo := "/9j/4AAQSkZJRgABAQAAAQABAAD/2wBDAAMCAgICAgMCAgIDAwMDBAYEBAQEBAgGBgUGCQgKCgkICQkKDA8MCgsOCwkJDRENDg8QEBEQCgwSExIQEw8QEBD/2wBDAQMDAwQDBAgEBAgQCwkLEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBD/wAARCAAKAAoDASIAAhEBAxEB/8QAFgABAQEAAAAAAAAAAAAAAAAABgcJ/8QAKBAAAQICCAcBAAAAAAAAAAAAAwQFAAECBhESExQjMQkYISIkVIOT/8QAFQEBAQAAAAAAAAAAAAAAAAAAAAX/xAAbEQACAQUAAAAAAAAAAAAAAAAAAgMEBRIUcf/aAAwDAQACEQMRAD8AYO3EBMjrTVpEtYnIKUxvMyhsYJgH0cb4xVebmrs+sngNk9taM/X4xk6pgy5aYsRl77lKdG9rG3s3gbnlvuH/AEnDacoVtuhwTh//2Q=="
+	// "%PDF-1.4\n"
+	pdf := "JVBERi0xLjQK"
+
+	tests := []struct {
+		GivenBody        map[string]interface{}
+		ExpectCode       int
+		ExpectPhotos     int
+		ExpectAttachment map[string]interface{}
+	}{
+		{
+			GivenBody: map[string]interface{}{
+				"body":  "hello",
+				"blobs": []interface{}{photo, photo},
+				"attachments": []interface{}{
+					map[string]interface{}{"name": "menu.pdf", "type": "application/pdf", "blob": pdf},
+				},
+			},
+			ExpectCode:       http.StatusCreated,
+			ExpectPhotos:     2,
+			ExpectAttachment: map[string]interface{}{"name": "menu.pdf", "contentType": "application/pdf", "size": float64(9)},
+		},
+		{
+			GivenBody: map[string]interface{}{
+				"body": "hello",
+				"attachments": []interface{}{
+					map[string]interface{}{"name": "page.html", "type": "text/html", "blob": pdf},
+				},
+			},
+			ExpectCode: http.StatusBadRequest,
+		},
+		{
+			GivenBody: map[string]interface{}{
+				"body":        "hello",
+				"attachments": []interface{}{"menu.pdf"},
+			},
+			ExpectCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, testCase := range tests {
+		_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", url, testCase.GivenBody, getAuthHeader(owner.Token))
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectCode)
+
+		if testCase.ExpectCode >= 400 {
+			continue
+		}
+
+		thelpers.AssertEqual(t, len(respData["photos"].([]interface{})), testCase.ExpectPhotos)
+
+		attachment := respData["attachments"].([]interface{})[0].(map[string]interface{})
+		for k, v := range testCase.ExpectAttachment {
+			thelpers.AssertEqual(t, attachment[k], v)
+		}
+		if attachment["url"] == "" {
+			t.Error("expected the attachment to have a URL")
+		}
+	}
+}
+
+func TestAddMessageToThreadWithMentions(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member1, _ := createTestUser(t)
+	member2, _ := createTestUser(t)
+	nonmember, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member1, &member2})
+
+	body := fmt.Sprintf("@%s and @%s, meet @%s. Not you, @%s",
+		member1.FirstName, member2.FullName, nonmember.FirstName, owner.FirstName)
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST",
+		fmt.Sprintf("/threads/%s/messages", thread.ID),
+		map[string]interface{}{"body": body},
+		getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+
+	mentions := respData["mentions"].([]interface{})
+	thelpers.AssertEqual(t, len(mentions), 2)
+	for _, id := range []string{member1.ID, member2.ID} {
+		var found bool
+		for i := range mentions {
+			found = found || mentions[i] == id
+		}
+		if !found {
+			t.Errorf("expected %s to be mentioned", id)
+		}
+	}
+}
+
+func TestAddReplyToThread(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member})
+	otherThread := createTestThread(t, &owner, []*models.User{&member})
+	message := createTestThreadMessage(t, &owner, &thread)
+	otherMessage := createTestThreadMessage(t, &owner, &otherThread)
+	url := fmt.Sprintf("/threads/%s/messages", thread.ID)
+
+	// Messages of other threads can't be replied to
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", url,
+		map[string]interface{}{"body": "reply", "replyTo": otherMessage.ID},
+		getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusBadRequest)
+	thelpers.AssertEqual(t, respData["replyTo"], "Invalid message")
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "POST", url,
+		map[string]interface{}{"body": "reply", "replyTo": message.ID},
+		getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+	replyTo := respData["replyTo"].(map[string]interface{})
+	thelpers.AssertEqual(t, replyTo["id"], message.ID)
+	thelpers.AssertEqual(t, replyTo["body"], message.Body)
+	thelpers.AssertEqual(t, replyTo["user"].(map[string]interface{})["id"], owner.ID)
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", url, nil, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	messages := respData["messages"].([]interface{})
+	reply := messages[len(messages)-1].(map[string]interface{})
+	thelpers.AssertEqual(t, reply["replyTo"].(map[string]interface{})["id"], message.ID)
+}
+
+func TestAddReplyToMessageOfDeletedUser(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	formerMember, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member, &formerMember})
+	message := createTestThreadMessage(t, &formerMember, &thread)
+
+	if err := db.DefaultClient.Delete(tc, formerMember.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST",
+		fmt.Sprintf("/threads/%s/messages", thread.ID),
+		map[string]interface{}{"body": "reply", "replyTo": message.ID},
+		getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+	replyTo := respData["replyTo"].(map[string]interface{})
+	thelpers.AssertEqual(t, replyTo["id"], message.ID)
+	thelpers.AssertEqual(t, replyTo["user"], nil)
+}
+
 ////////////////////////////////////
 // DELETE /threads/{id}/messages/{id} Tests
 ////////////////////////////////////
@@ -177,6 +325,147 @@ func TestDeleteThreadMessage(t *testing.T) {
 	}
 }
 
+////////////////////////////////////
+// PATCH /threads/{id}/messages/{id} Tests
+////////////////////////////////////
+
+func TestEditThreadMessage(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	nonmember, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member})
+	message := createTestThreadMessage(t, &owner, &thread)
+	url := fmt.Sprintf("/threads/%s/messages/%s", thread.ID, message.ID)
+
+	tests := []struct {
+		GivenAuthHeader map[string]string
+		GivenBody       map[string]interface{}
+		ExpectCode      int
+	}{
+		{GivenAuthHeader: getAuthHeader(member.Token), GivenBody: map[string]interface{}{"body": "edited"}, ExpectCode: http.StatusNotFound},
+		{GivenAuthHeader: getAuthHeader(nonmember.Token), GivenBody: map[string]interface{}{"body": "edited"}, ExpectCode: http.StatusNotFound},
+		{GivenAuthHeader: getAuthHeader(owner.Token), GivenBody: map[string]interface{}{}, ExpectCode: http.StatusBadRequest},
+		{GivenAuthHeader: getAuthHeader(owner.Token), GivenBody: map[string]interface{}{"body": "edited"}, ExpectCode: http.StatusOK},
+	}
+
+	for _, testCase := range tests {
+		_, rr, respData := thelpers.TestEndpoint(t, tc, th, "PATCH", url, testCase.GivenBody, testCase.GivenAuthHeader)
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectCode)
+
+		if testCase.ExpectCode >= 400 {
+			continue
+		}
+
+		thelpers.AssertEqual(t, respData["body"], "edited")
+		edits := respData["edits"].([]interface{})
+		thelpers.AssertEqual(t, len(edits), 1)
+		thelpers.AssertEqual(t, edits[0].(map[string]interface{})["body"], message.Body)
+	}
+
+	// The message was the first of the thread, so the preview shows the edit.
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "GET", fmt.Sprintf("/threads/%s", thread.ID), nil, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["preview"].(map[string]interface{})["body"], "edited")
+}
+
+func TestEditThreadMessageWithMentions(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member1, _ := createTestUser(t)
+	member2, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member1, &member2})
+	message := createTestThreadMessage(t, &owner, &thread)
+	url := fmt.Sprintf("/threads/%s/messages/%s", thread.ID, message.ID)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "PATCH", url,
+		map[string]interface{}{"body": fmt.Sprintf("@%s", member1.FirstName)},
+		getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	mentions := respData["mentions"].([]interface{})
+	thelpers.AssertEqual(t, len(mentions), 1)
+	thelpers.AssertEqual(t, mentions[0], member1.ID)
+
+	// Mentions that are taken out of the message are removed
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "PATCH", url,
+		map[string]interface{}{"body": fmt.Sprintf("@%s", member2.FirstName)},
+		getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	mentions = respData["mentions"].([]interface{})
+	thelpers.AssertEqual(t, len(mentions), 1)
+	thelpers.AssertEqual(t, mentions[0], member2.ID)
+}
+
+func TestEditThreadMessageAfterWindow(t *testing.T) {
+	owner, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{})
+	message := createTestThreadMessage(t, &owner, &thread)
+
+	message.Timestamp = time.Now().Add(-24 * time.Hour)
+	if err := message.Commit(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	url := fmt.Sprintf("/threads/%s/messages/%s", thread.ID, message.ID)
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "PATCH", url, map[string]interface{}{"body": "edited"}, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusBadRequest)
+	thelpers.AssertEqual(t, respData["message"], "This message can no longer be edited")
+}
+
+////////////////////////////////////
+// POST & DELETE /threads/{id}/messages/{id}/reactions Tests
+////////////////////////////////////
+
+func TestReactToThreadMessage(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	nonmember, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member})
+	message := createTestThreadMessage(t, &owner, &thread)
+	url := fmt.Sprintf("/threads/%s/messages/%s/reactions", thread.ID, message.ID)
+
+	_, _, unreadBefore := thelpers.TestEndpoint(t, tc, th, "GET", "/users/unread", nil, getAuthHeader(owner.Token))
+
+	tests := []struct {
+		GivenAuthHeader map[string]string
+		GivenBody       map[string]interface{}
+		ExpectCode      int
+		ExpectCount     int
+	}{
+		{GivenAuthHeader: getAuthHeader(nonmember.Token), GivenBody: map[string]interface{}{"emoji": "👍"}, ExpectCode: http.StatusNotFound},
+		{GivenAuthHeader: getAuthHeader(member.Token), GivenBody: map[string]interface{}{}, ExpectCode: http.StatusBadRequest},
+		{GivenAuthHeader: getAuthHeader(member.Token), GivenBody: map[string]interface{}{"emoji": "not an emoji"}, ExpectCode: http.StatusBadRequest},
+		{GivenAuthHeader: getAuthHeader(member.Token), GivenBody: map[string]interface{}{"emoji": "👍"}, ExpectCode: http.StatusOK, ExpectCount: 1},
+		// Reacting twice with the same emoji counts once
+		{GivenAuthHeader: getAuthHeader(member.Token), GivenBody: map[string]interface{}{"emoji": "👍"}, ExpectCode: http.StatusOK, ExpectCount: 1},
+		{GivenAuthHeader: getAuthHeader(owner.Token), GivenBody: map[string]interface{}{"emoji": "👍"}, ExpectCode: http.StatusOK, ExpectCount: 2},
+	}
+
+	for _, testCase := range tests {
+		_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", url, testCase.GivenBody, testCase.GivenAuthHeader)
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectCode)
+
+		if testCase.ExpectCode >= 400 {
+			continue
+		}
+
+		reactions := respData["reactions"].([]interface{})
+		thelpers.AssertEqual(t, len(reactions), 1)
+		reaction := reactions[0].(map[string]interface{})
+		thelpers.AssertEqual(t, reaction["emoji"], "👍")
+		thelpers.AssertEqual(t, reaction["count"], float64(testCase.ExpectCount))
+	}
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "DELETE", url, map[string]interface{}{"emoji": "👍"}, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	reaction := respData["reactions"].([]interface{})[0].(map[string]interface{})
+	thelpers.AssertEqual(t, reaction["count"], float64(1))
+	thelpers.AssertEqual(t, reaction["userIds"], []interface{}{owner.ID})
+
+	// Reactions don't change what is unread
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", "/users/unread", nil, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["threads"], unreadBefore["threads"])
+}
+
 ///////////////////////////////////
 // GET /threads/{id}/messages Tests
 ///////////////////////////////////
@@ -222,6 +511,66 @@ func TestGetThreadMessages(t *testing.T) {
 	}
 }
 
+func TestGetThreadMessagesWithCursor(t *testing.T) {
+	owner, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{})
+
+	var want []string
+	for i := 0; i < 5; i++ {
+		want = append(want, createTestThreadMessage(t, &owner, &thread).ID)
+	}
+
+	// Follow the cursors two messages at a time
+	var got []string
+	url := fmt.Sprintf("/threads/%s/messages?size=2", thread.ID)
+	for pages := 0; pages < 3; pages++ {
+		_, rr, respData := thelpers.TestEndpoint(t, tc, th, "GET", url, nil, getAuthHeader(owner.Token))
+		thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+
+		for _, m := range respData["messages"].([]interface{}) {
+			got = append(got, m.(map[string]interface{})["id"].(string))
+		}
+
+		url = fmt.Sprintf("/threads/%s/messages?size=2&cursor=%s", thread.ID, respData["nextCursor"])
+	}
+
+	thelpers.AssertEqual(t, got, want)
+
+	_, rr, _ := thelpers.TestEndpoint(t, tc, th, "GET", fmt.Sprintf("/threads/%s/messages?cursor=nope", thread.ID), nil, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusBadRequest)
+}
+
+func TestGetThreadMessagesReadBy(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	private, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member, &private})
+	createTestThreadMessage(t, &owner, &thread)
+	url := fmt.Sprintf("/threads/%s/messages", thread.ID)
+
+	_, rr, _ := thelpers.TestEndpoint(t, tc, th, "PATCH", "/users", map[string]interface{}{"hideReadReceipts": true}, getAuthHeader(private.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+
+	for _, u := range []models.User{member, private} {
+		_, rr, _ = thelpers.TestEndpoint(t, tc, th, "POST", fmt.Sprintf("/threads/%s/reads", thread.ID), nil, getAuthHeader(u.Token))
+		thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	}
+
+	// Others don't see that the private member has read the message
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "GET", url, nil, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	readBy := respData["messages"].([]interface{})[0].(map[string]interface{})["readBy"].([]interface{})
+	thelpers.AssertEqual(t, len(readBy), 1)
+	thelpers.AssetObjectsContainKeys(t, "id", []string{member.ID}, readBy)
+
+	// But the private member does
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", url, nil, getAuthHeader(private.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	readBy = respData["messages"].([]interface{})[0].(map[string]interface{})["readBy"].([]interface{})
+	thelpers.AssertEqual(t, len(readBy), 2)
+	thelpers.AssetObjectsContainKeys(t, "id", []string{member.ID, private.ID}, readBy)
+}
+
 ////////////////////////////////////
 // POST /events/{id}/messages Tests
 ////////////////////////////////////
@@ -312,6 +661,22 @@ func TestAddMessageToEvent(t *testing.T) {
 	}
 }
 
+func TestAddReplyToEvent(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	event := createTestEvent(t, &owner, []*models.User{&member}, []*models.User{})
+	message := createTestEventMessage(t, &owner, event)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST",
+		fmt.Sprintf("/events/%s/messages", event.ID),
+		map[string]interface{}{"body": "reply", "replyTo": message.ID},
+		getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+	replyTo := respData["replyTo"].(map[string]interface{})
+	thelpers.AssertEqual(t, replyTo["id"], message.ID)
+	thelpers.AssertEqual(t, replyTo["body"], message.Body)
+}
+
 ////////////////////////////////////
 // DELETE /events/{id}/messages/{id} Tests
 ////////////////////////////////////
@@ -385,6 +750,52 @@ func TestDeleteEventMessage(t *testing.T) {
 	}
 }
 
+////////////////////////////////////
+// PATCH /events/{id}/messages/{id} Tests
+////////////////////////////////////
+
+func TestEditEventMessage(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	event := createTestEvent(t, &owner, []*models.User{&member}, []*models.User{})
+	message := createTestEventMessage(t, &member, event)
+	url := fmt.Sprintf("/events/%s/messages/%s", event.ID, message.ID)
+
+	_, rr, _ := thelpers.TestEndpoint(t, tc, th, "PATCH", url, map[string]interface{}{"body": "edited"}, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusNotFound)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "PATCH", url, map[string]interface{}{"body": "edited"}, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["body"], "edited")
+	thelpers.AssertEqual(t, len(respData["edits"].([]interface{})), 1)
+}
+
+////////////////////////////////////
+// POST & DELETE /events/{id}/messages/{id}/reactions Tests
+////////////////////////////////////
+
+func TestReactToEventMessage(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	nonmember, _ := createTestUser(t)
+	event := createTestEvent(t, &owner, []*models.User{&member}, []*models.User{})
+	message := createTestEventMessage(t, &owner, event)
+	url := fmt.Sprintf("/events/%s/messages/%s/reactions", event.ID, message.ID)
+
+	_, rr, _ := thelpers.TestEndpoint(t, tc, th, "POST", url, map[string]interface{}{"emoji": "🎉"}, getAuthHeader(nonmember.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusNotFound)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", url, map[string]interface{}{"emoji": "🎉"}, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	reaction := respData["reactions"].([]interface{})[0].(map[string]interface{})
+	thelpers.AssertEqual(t, reaction["count"], float64(1))
+	thelpers.AssertEqual(t, reaction["userIds"], []interface{}{member.ID})
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "DELETE", url, map[string]interface{}{"emoji": "🎉"}, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["reactions"], nil)
+}
+
 ///////////////////////////////////
 // GET /threads/{id}/messages Tests
 ///////////////////////////////////
@@ -66,3 +66,74 @@ func TestMarkEventAsRead(t *testing.T) {
 		thelpers.AssertStatusCodeEqual(t, rr, testCase.OutCode)
 	}
 }
+
+func TestMarkThreadAsReadUntilNewMessage(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member})
+	createTestThreadMessage(t, &owner, &thread)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", fmt.Sprintf("/threads/%s/reads", thread.ID), nil, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, readIDs(respData), []string{member.ID})
+
+	_, rr, _ = thelpers.TestEndpoint(t, tc, th, "POST", fmt.Sprintf("/threads/%s/messages", thread.ID), map[string]interface{}{"body": "hello"}, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+
+	// The new message is unread by the member but read by its sender
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", fmt.Sprintf("/threads/%s", thread.ID), nil, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, readIDs(respData), []string{owner.ID})
+}
+
+func readIDs(respData map[string]interface{}) []string {
+	var ids []string
+	reads, _ := respData["reads"].([]interface{})
+	for i := range reads {
+		ids = append(ids, reads[i].(map[string]interface{})["id"].(string))
+	}
+
+	return ids
+}
+
+//////////////////////
+// GET /users/unread Tests
+//////////////////////
+
+func TestGetUnreadCounts(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member})
+	event := createTestEvent(t, &owner, []*models.User{&member}, []*models.User{})
+	for i := 0; i < 3; i++ {
+		createTestThreadMessage(t, &owner, &thread)
+	}
+	for i := 0; i < 2; i++ {
+		createTestEventMessage(t, &owner, event)
+	}
+	if err := event.Commit(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "GET", "/users/unread", nil, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["total"], float64(2))
+	thelpers.AssertEqual(t, respData["threads"], map[string]interface{}{thread.ID: float64(3)})
+	thelpers.AssertEqual(t, respData["events"], map[string]interface{}{event.ID: float64(2)})
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", "/threads", nil, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	threads := respData["threads"].([]interface{})
+	thelpers.AssertEqual(t, threads[0].(map[string]interface{})["unreadCount"], float64(3))
+
+	_, rr, _ = thelpers.TestEndpoint(t, tc, th, "POST", fmt.Sprintf("/threads/%s/reads", thread.ID), nil, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", "/users/unread", nil, getAuthHeader(member.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["total"], float64(1))
+	thelpers.AssertEqual(t, respData["threads"], map[string]interface{}{})
+
+	_, rr, _ = thelpers.TestEndpoint(t, tc, th, "GET", "/users/unread", nil, nil)
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusUnauthorized)
+}
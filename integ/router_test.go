@@ -50,7 +50,7 @@ func Test404(t *testing.T) {
 	thelpers.AssertStatusCodeEqual(t, rr, http.StatusNotFound)
 }
 
-func createTestUser(t *testing.T) (models.User, string) {
+func createTestUser(t testing.TB) (models.User, string) {
 	password := random.String(20)
 	u, err := models.NewUserWithPassword(
 		strings.ToLower(fmt.Sprintf("%s@test.com", random.String(20))),
@@ -73,7 +73,7 @@ func createTestUser(t *testing.T) (models.User, string) {
 	return u, password
 }
 
-func createTestThread(t *testing.T, owner *models.User, users []*models.User) models.Thread {
+func createTestThread(t testing.TB, owner *models.User, users []*models.User) models.Thread {
 	// Create the thread.
 	thread, err := models.NewThread("test", owner, users)
 	if err != nil {
@@ -88,8 +88,8 @@ func createTestThread(t *testing.T, owner *models.User, users []*models.User) mo
 	return thread
 }
 
-func createTestThreadMessage(t *testing.T, user *models.User, thread *models.Thread) models.Message {
-	message, err := models.NewThreadMessage(user, thread, random.String(50), "", og.LinkData{})
+func createTestThreadMessage(t testing.TB, user *models.User, thread *models.Thread) models.Message {
+	message, err := models.NewThreadMessage(user, thread, random.String(50), nil, nil, og.LinkData{}, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -106,7 +106,7 @@ func createTestThreadMessage(t *testing.T, user *models.User, thread *models.Thr
 	return message
 }
 
-func createTestEvent(t *testing.T, owner *models.User, users, hosts []*models.User) *models.Event {
+func createTestEvent(t testing.TB, owner *models.User, users, hosts []*models.User) *models.Event {
 	// Create the thread.
 	event, err := models.NewEvent(
 		"test",
@@ -135,8 +135,8 @@ func createTestEvent(t *testing.T, owner *models.User, users, hosts []*models.Us
 	return eptr
 }
 
-func createTestEventMessage(t *testing.T, user *models.User, event *models.Event) models.Message {
-	message, err := models.NewEventMessage(user, event, random.String(50), "")
+func createTestEventMessage(t testing.TB, user *models.User, event *models.Event) models.Message {
+	message, err := models.NewEventMessage(user, event, random.String(50), nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,6 +53,14 @@ func TestSendEmailsAsync(t *testing.T) {
 			},
 			ExpectStatus: 200,
 		},
+		{
+			GivenBody: fmt.Sprintf(`{ "ids": ["%v"], "type": "User", "action": "SendDigest" }`, member1.ID),
+			GivenHeaders: map[string]string{
+				"Content-Type":          "application/json",
+				"X-Appengine-Queuename": "convo-emails",
+			},
+			ExpectStatus: 200,
+		},
 		// Invalid payload
 		{
 			GivenBody:    fmt.Sprintf(`{ "ids": ["%v"], "type": "Thread", "action": "SendInvites" }`, event.ID),
@@ -79,3 +87,32 @@ func TestSendEmailsAsync(t *testing.T) {
 			End()
 	}
 }
+
+func TestCreateDigest(t *testing.T) {
+	createTestUser(t)
+
+	tests := []struct {
+		GivenHeaders map[string]string
+		ExpectStatus int
+	}{
+		{
+			GivenHeaders: map[string]string{"X-Appengine-Cron": "true"},
+			ExpectStatus: 200,
+		},
+		// Missing header
+		{
+			GivenHeaders: map[string]string{},
+			ExpectStatus: 404,
+		},
+	}
+
+	for _, testCase := range tests {
+		apitest.New("CreateDigest").
+			Handler(th).
+			Get("/tasks/digest").
+			Headers(testCase.GivenHeaders).
+			Expect(t).
+			Status(testCase.ExpectStatus).
+			End()
+	}
+}
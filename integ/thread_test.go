@@ -260,6 +260,10 @@ func TestDeleteThread(t *testing.T) {
 	thread := createTestThread(t, &owner, []*models.User{&member})
 	url := fmt.Sprintf("/threads/%s", thread.ID)
 
+	if err := models.MarkAsRead(tc, &thread, owner.Key, member.Key); err != nil {
+		t.Fatal(err)
+	}
+
 	type test struct {
 		AuthHeader map[string]string
 		OutCode    int
@@ -283,6 +287,13 @@ func TestDeleteThread(t *testing.T) {
 			var gotThread models.Thread
 			err := tclient.Get(tc, thread.Key, &gotThread)
 			thelpers.AssertEqual(t, err, datastore.ErrNoSuchEntity)
+
+			// Its read cursors are deleted along with it
+			readKeys, err := tclient.GetAll(tc, datastore.NewQuery("Read").Ancestor(thread.Key).KeysOnly(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			thelpers.AssertEqual(t, len(readKeys), 0)
 		}
 	}
 }
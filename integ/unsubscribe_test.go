@@ -0,0 +1,61 @@
+package router_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hiconvo/api/models"
+	"github.com/hiconvo/api/utils/magic"
+	"github.com/hiconvo/api/utils/thelpers"
+)
+
+////////////////////////////////////////////////////////
+// GET, POST /unsubscribe/{category}/{userID}/{signature}
+////////////////////////////////////////////////////////
+
+func TestUnsubscribe(t *testing.T) {
+	u, _ := createTestUser(t)
+
+	link, err := url.Parse(magic.NewUnsubscribeLink(u.Key, models.EmailCategoryDigest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := strings.Replace(link.Path, models.EmailCategoryDigest, models.EmailCategoryThreads, 1)
+
+	type test struct {
+		Method       string
+		Path         string
+		ExpectStatus int
+	}
+
+	tests := []test{
+		{Method: "GET", Path: link.Path, ExpectStatus: http.StatusOK},
+		{Method: "POST", Path: forged, ExpectStatus: http.StatusNotFound},
+		{Method: "POST", Path: link.Path, ExpectStatus: http.StatusOK},
+	}
+
+	for _, testCase := range tests {
+		req, err := http.NewRequest(testCase.Method, testCase.Path, strings.NewReader("List-Unsubscribe=One-Click"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		th.ServeHTTP(rr, req)
+
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectStatus)
+	}
+
+	refreshedUser, err := models.GetUserByID(tc, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thelpers.AssertEqual(t, refreshedUser.IsUnsubscribed(models.EmailCategoryDigest), true)
+	thelpers.AssertEqual(t, refreshedUser.IsUnsubscribed(models.EmailCategoryThreads), false)
+}
@@ -0,0 +1,396 @@
+package router_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hiconvo/api/models"
+	"github.com/hiconvo/api/utils/thelpers"
+)
+
+////////////////////////////////////
+// POST /uploads (multipart) Tests
+////////////////////////////////////
+
+func TestCreateUploadFromForm(t *testing.T) {
+	u, _ := createTestUser(t)
+
+	tests := []struct {
+		GivenName        string
+		GivenContentType string
+		GivenFile        string
+		ExpectCode       int
+		ExpectSize       float64
+	}{
+		{
+			GivenName:        "notes.txt",
+			GivenContentType: "text/plain; charset=utf-8",
+			GivenFile:        "hello world",
+			ExpectCode:       http.StatusCreated,
+			ExpectSize:       11,
+		},
+		{
+			GivenName:        "page.html",
+			GivenContentType: "text/html",
+			GivenFile:        "<p>hello</p>",
+			ExpectCode:       http.StatusBadRequest,
+		},
+		{
+			GivenName:        "empty.txt",
+			GivenContentType: "text/plain",
+			GivenFile:        "",
+			ExpectCode:       http.StatusBadRequest,
+		},
+	}
+
+	for _, testCase := range tests {
+		rr, respData := postUploadForm(t, &u, testCase.GivenName, testCase.GivenContentType, testCase.GivenFile)
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectCode)
+
+		if testCase.ExpectCode >= 400 {
+			continue
+		}
+
+		thelpers.AssertEqual(t, respData["name"], testCase.GivenName)
+		thelpers.AssertEqual(t, respData["contentType"], "text/plain")
+		thelpers.AssertEqual(t, respData["size"], testCase.ExpectSize)
+		thelpers.AssertEqual(t, respData["complete"], true)
+	}
+}
+
+////////////////////////////////////
+// PUT /uploads/{id} Tests
+////////////////////////////////////
+
+func TestUploadInParts(t *testing.T) {
+	owner, _ := createTestUser(t)
+	stranger, _ := createTestUser(t)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", "/uploads", map[string]interface{}{
+		"name": "notes.txt",
+		"type": "text/plain",
+		"size": 11,
+	}, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+	thelpers.AssertEqual(t, respData["complete"], false)
+
+	url := fmt.Sprintf("/uploads/%s", respData["id"].(string))
+
+	tests := []struct {
+		AuthUser          *models.User
+		GivenContentRange string
+		GivenBody         string
+		ExpectCode        int
+		ExpectReceived    float64
+		ExpectComplete    bool
+	}{
+		{
+			AuthUser:          &stranger,
+			GivenContentRange: "bytes 0-5/11",
+			GivenBody:         "hello ",
+			ExpectCode:        http.StatusNotFound,
+		},
+		{
+			AuthUser:          &owner,
+			GivenContentRange: "bytes 0-5/12",
+			GivenBody:         "hello ",
+			ExpectCode:        http.StatusBadRequest,
+		},
+		{
+			AuthUser:          &owner,
+			GivenContentRange: "bytes 0-11/11",
+			GivenBody:         "hello world!",
+			ExpectCode:        http.StatusBadRequest,
+		},
+		// The body must be as long as the range says
+		{
+			AuthUser:          &owner,
+			GivenContentRange: "bytes 0-2/11",
+			GivenBody:         "hello ",
+			ExpectCode:        http.StatusBadRequest,
+		},
+		{
+			AuthUser:          &owner,
+			GivenContentRange: "bytes 0-7/11",
+			GivenBody:         "hello ",
+			ExpectCode:        http.StatusBadRequest,
+		},
+		{
+			AuthUser:          &owner,
+			GivenContentRange: "bytes 0-5/11",
+			GivenBody:         "hello ",
+			ExpectCode:        http.StatusOK,
+			ExpectReceived:    6,
+		},
+		{
+			AuthUser:          &owner,
+			GivenContentRange: "bytes 0-5/11",
+			GivenBody:         "hello ",
+			ExpectCode:        http.StatusConflict,
+		},
+		{
+			AuthUser:          &owner,
+			GivenContentRange: "bytes 6-10/11",
+			GivenBody:         "world",
+			ExpectCode:        http.StatusOK,
+			ExpectReceived:    11,
+			ExpectComplete:    true,
+		},
+	}
+
+	for _, testCase := range tests {
+		rr, respData := putUpload(t, url, testCase.AuthUser, testCase.GivenContentRange, testCase.GivenBody)
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectCode)
+
+		if testCase.ExpectCode >= 400 {
+			continue
+		}
+
+		thelpers.AssertEqual(t, respData["received"], testCase.ExpectReceived)
+		thelpers.AssertEqual(t, respData["complete"], testCase.ExpectComplete)
+	}
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", url, nil, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["complete"], true)
+
+	_, rr, _ = thelpers.TestEndpoint(t, tc, th, "GET", url, nil, getAuthHeader(stranger.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusNotFound)
+}
+
+func TestAddMessageToThreadWithUploads(t *testing.T) {
+	owner, _ := createTestUser(t)
+	member, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{&member})
+	url := fmt.Sprintf("/threads/%s/messages", thread.ID)
+
+	_, upload := postUploadForm(t, &owner, "notes.txt", "text/plain", "hello world")
+	_, memberUpload := postUploadForm(t, &member, "notes.txt", "text/plain", "hello world")
+
+	tests := []struct {
+		GivenUploads []interface{}
+		ExpectCode   int
+	}{
+		{
+			GivenUploads: []interface{}{memberUpload["id"]},
+			ExpectCode:   http.StatusBadRequest,
+		},
+		{
+			GivenUploads: []interface{}{upload["id"]},
+			ExpectCode:   http.StatusCreated,
+		},
+		// Uploads are gone once they are attached
+		{
+			GivenUploads: []interface{}{upload["id"]},
+			ExpectCode:   http.StatusBadRequest,
+		},
+	}
+
+	for _, testCase := range tests {
+		_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", url, map[string]interface{}{
+			"body":    "hello",
+			"uploads": testCase.GivenUploads,
+		}, getAuthHeader(owner.Token))
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectCode)
+
+		if testCase.ExpectCode >= 400 {
+			continue
+		}
+
+		attachment := respData["attachments"].([]interface{})[0].(map[string]interface{})
+		thelpers.AssertEqual(t, attachment["name"], "notes.txt")
+		thelpers.AssertEqual(t, attachment["contentType"], "text/plain")
+		thelpers.AssertEqual(t, attachment["size"], float64(11))
+	}
+}
+
+func TestAddMessageToThreadWithHugeImage(t *testing.T) {
+	owner, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{})
+
+	// Only a few bytes, but it would take gigabytes to decode
+	rr, upload := postUploadForm(t, &owner, "huge.png", "image/png", newPNGHeader(30000, 30000))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", fmt.Sprintf("/threads/%s/messages", thread.ID), map[string]interface{}{
+		"body":    "hello",
+		"uploads": []interface{}{upload["id"]},
+	}, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusBadRequest)
+	thelpers.AssertEqual(t, respData["message"], "Images can be up to 50 megapixels")
+}
+
+func TestStorageUsage(t *testing.T) {
+	owner, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{})
+	url := fmt.Sprintf("/threads/%s/messages", thread.ID)
+
+	// The first message of a thread can't be deleted
+	_, rr, _ := thelpers.TestEndpoint(t, tc, th, "POST", url, map[string]interface{}{
+		"body": "hello",
+	}, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+
+	_, upload := postUploadForm(t, &owner, "notes.txt", "text/plain", "hello world")
+	_, rr, message := thelpers.TestEndpoint(t, tc, th, "POST", url, map[string]interface{}{
+		"body":    "hello",
+		"uploads": []interface{}{upload["id"]},
+	}, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "GET", "/users", nil, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["storageUsed"], float64(11))
+	thelpers.AssertEqual(t, respData["storageQuota"], float64(1<<30))
+
+	_, rr, _ = thelpers.TestEndpoint(t, tc, th, "DELETE", fmt.Sprintf("%s/%s", url, message["id"]), nil, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", "/users", nil, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["storageUsed"], float64(0))
+}
+
+////////////////////////////////////
+// GET /files Tests
+////////////////////////////////////
+
+func TestGetFile(t *testing.T) {
+	owner, _ := createTestUser(t)
+	thread := createTestThread(t, &owner, []*models.User{})
+
+	_, upload := postUploadForm(t, &owner, "notes.txt", "text/plain", "hello world")
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", fmt.Sprintf("/threads/%s/messages", thread.ID), map[string]interface{}{
+		"body":    "hello",
+		"uploads": []interface{}{upload["id"]},
+	}, getAuthHeader(owner.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusCreated)
+
+	attachment := respData["attachments"].([]interface{})[0].(map[string]interface{})
+	signedURL, err := url.Parse(attachment["url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := signedURL.Query()
+	tampered.Set("obj", "uploads/another.txt")
+
+	tests := []struct {
+		GivenQuery string
+		ExpectCode int
+		ExpectBody string
+	}{
+		{
+			GivenQuery: signedURL.RawQuery,
+			ExpectCode: http.StatusOK,
+			ExpectBody: "hello world",
+		},
+		{
+			GivenQuery: tampered.Encode(),
+			ExpectCode: http.StatusNotFound,
+		},
+		{
+			GivenQuery: "",
+			ExpectCode: http.StatusNotFound,
+		},
+	}
+
+	for _, testCase := range tests {
+		req, err := http.NewRequest("GET", "/files?"+testCase.GivenQuery, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		th.ServeHTTP(rr, req.WithContext(tc))
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectCode)
+
+		if testCase.ExpectCode >= 400 {
+			continue
+		}
+
+		thelpers.AssertEqual(t, rr.Body.String(), testCase.ExpectBody)
+		thelpers.AssertEqual(t, rr.Header().Get("Content-Type"), "text/plain")
+	}
+}
+
+func postUploadForm(t *testing.T, u *models.User, name, contentType, file string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var b bytes.Buffer
+	form := multipart.NewWriter(&b)
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, name))
+	h.Set("Content-Type", contentType)
+	part, err := form.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, file)
+
+	form.Close()
+
+	req, err := http.NewRequest("POST", "/uploads", &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", form.FormDataContentType())
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", u.Token))
+
+	return serveUploadRequest(t, req)
+}
+
+func putUpload(t *testing.T, url string, u *models.User, contentRange, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, err := http.NewRequest("PUT", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/octet-stream")
+	req.Header.Add("Content-Range", contentRange)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", u.Token))
+
+	return serveUploadRequest(t, req)
+}
+
+func serveUploadRequest(t *testing.T, req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+	rr := httptest.NewRecorder()
+	th.ServeHTTP(rr, req.WithContext(tc))
+
+	var respData map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &respData); err != nil {
+		t.Fatal(err)
+	}
+
+	return rr, respData
+}
+
+// newPNGHeader returns the start of a PNG with the given dimensions, which
+// is enough for them to be read but not for the image to be decoded.
+func newPNGHeader(width, height uint32) string {
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // Bit depth
+	ihdr[9] = 2 // RGB
+
+	binary.Write(&b, binary.BigEndian, uint32(len(ihdr)))
+	b.WriteString("IHDR")
+	b.Write(ihdr)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(append([]byte("IHDR"), ihdr...)))
+
+	return b.String()
+}
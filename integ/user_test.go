@@ -26,8 +26,10 @@ func TestCreateUser(t *testing.T) {
 
 	tests := []struct {
 		GivenBody    map[string]interface{}
+		GivenHeaders map[string]string
 		ExpectStatus int
 		ExpectBody   string
+		ExpectLocale string
 	}{
 		{
 			GivenBody: map[string]interface{}{
@@ -38,6 +40,19 @@ func TestCreateUser(t *testing.T) {
 			},
 			ExpectStatus: http.StatusCreated,
 			ExpectBody:   "",
+			ExpectLocale: "en",
+		},
+		{
+			GivenBody: map[string]interface{}{
+				"email":     "jose.ortega@ucm.es",
+				"firstName": "José",
+				"lastName":  "Ortega y Gasset",
+				"password":  "I am I and my circumstance",
+			},
+			GivenHeaders: map[string]string{"Accept-Language": "es-ES,es;q=0.9,en;q=0.8"},
+			ExpectStatus: http.StatusCreated,
+			ExpectBody:   "",
+			ExpectLocale: "es",
 		},
 		{
 			GivenBody: map[string]interface{}{
@@ -80,7 +95,7 @@ func TestCreateUser(t *testing.T) {
 	}
 
 	for _, testCase := range tests {
-		_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", "/users", testCase.GivenBody, nil)
+		_, rr, respData := thelpers.TestEndpoint(t, tc, th, "POST", "/users", testCase.GivenBody, testCase.GivenHeaders)
 
 		assert.Equal(t, testCase.ExpectStatus, rr.Result().StatusCode)
 
@@ -90,6 +105,7 @@ func TestCreateUser(t *testing.T) {
 			assert.Equal(t, testCase.GivenBody["email"], respData["email"])
 			assert.Equal(t, testCase.GivenBody["firstName"], respData["firstName"])
 			assert.Equal(t, testCase.GivenBody["lastName"], respData["lastName"])
+			assert.Equal(t, testCase.ExpectLocale, respData["locale"])
 		}
 	}
 }
@@ -413,6 +429,88 @@ func TestUpdateUser(t *testing.T) {
 				"email":     existingUser.Email,
 			},
 		},
+		{
+			GivenAuthHeader: getAuthHeader(existingUser.Token),
+			GivenBody: map[string]interface{}{
+				"notificationPreference": "weeklyDigest",
+			},
+			ExpectStatus: http.StatusOK,
+			OutData: map[string]interface{}{
+				"id":                     existingUser.ID,
+				"firstName":              "Sir",
+				"lastName":               "Malebranche",
+				"token":                  existingUser.Token,
+				"verified":               existingUser.Verified,
+				"email":                  existingUser.Email,
+				"notificationPreference": "weeklyDigest",
+			},
+		},
+		{
+			GivenAuthHeader: getAuthHeader(existingUser.Token),
+			GivenBody: map[string]interface{}{
+				"notificationPreference": "hourlyDigest",
+			},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   `{"notificationPreference":"Nope"}`,
+		},
+		{
+			GivenAuthHeader: getAuthHeader(existingUser.Token),
+			GivenBody: map[string]interface{}{
+				"locale": "es",
+			},
+			ExpectStatus: http.StatusOK,
+			OutData: map[string]interface{}{
+				"id":        existingUser.ID,
+				"firstName": "Sir",
+				"lastName":  "Malebranche",
+				"token":     existingUser.Token,
+				"verified":  existingUser.Verified,
+				"email":     existingUser.Email,
+				"locale":    "es",
+			},
+		},
+		{
+			GivenAuthHeader: getAuthHeader(existingUser.Token),
+			GivenBody: map[string]interface{}{
+				"locale": "tlh",
+			},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   `{"locale":"Nope"}`,
+		},
+		{
+			GivenAuthHeader: getAuthHeader(existingUser.Token),
+			GivenBody: map[string]interface{}{
+				"timezone":   "America/Mexico_City",
+				"digestHour": 0,
+			},
+			ExpectStatus: http.StatusOK,
+			OutData: map[string]interface{}{
+				"id":         existingUser.ID,
+				"firstName":  "Sir",
+				"lastName":   "Malebranche",
+				"token":      existingUser.Token,
+				"verified":   existingUser.Verified,
+				"email":      existingUser.Email,
+				"timezone":   "America/Mexico_City",
+				"digestHour": float64(0),
+			},
+		},
+		{
+			GivenAuthHeader: getAuthHeader(existingUser.Token),
+			GivenBody: map[string]interface{}{
+				"timezone": "Mars/Olympus_Mons",
+			},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   `{"timezone":"Nope"}`,
+		},
+		{
+			GivenAuthHeader: getAuthHeader(existingUser.Token),
+			GivenBody: map[string]interface{}{
+				"digestHour": 24,
+			},
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   `{"digestHour":"Nope"}`,
+		},
 	}
 
 	for _, testCase := range tests {
@@ -429,6 +527,19 @@ func TestUpdateUser(t *testing.T) {
 			thelpers.AssertEqual(t, respData["token"], testCase.OutData["token"])
 			thelpers.AssertEqual(t, respData["verified"], testCase.OutData["verified"])
 			thelpers.AssertEqual(t, respData["email"], testCase.OutData["email"])
+
+			if pref, ok := testCase.OutData["notificationPreference"]; ok {
+				thelpers.AssertEqual(t, respData["notificationPreference"], pref)
+			}
+
+			if locale, ok := testCase.OutData["locale"]; ok {
+				thelpers.AssertEqual(t, respData["locale"], locale)
+			}
+
+			if timezone, ok := testCase.OutData["timezone"]; ok {
+				thelpers.AssertEqual(t, respData["timezone"], timezone)
+				thelpers.AssertEqual(t, respData["digestHour"], testCase.OutData["digestHour"])
+			}
 		}
 	}
 }
@@ -0,0 +1,94 @@
+package router_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hiconvo/api/mail"
+	"github.com/hiconvo/api/utils/thelpers"
+)
+
+////////////////////////////
+// POST /webhooks/email Tests
+////////////////////////////
+
+func TestEmailEvents(t *testing.T) {
+	token := os.Getenv("EMAIL_WEBHOOK_TOKEN")
+	if token == "" {
+		t.Skip("EMAIL_WEBHOOK_TOKEN is not set")
+	}
+
+	bouncingUser, _ := createTestUser(t)
+	complainingUser, _ := createTestUser(t)
+	invalidUser, _ := createTestUser(t)
+	droppedUser, _ := createTestUser(t)
+
+	payload := fmt.Sprintf(`[
+		{"email": "%s", "event": "bounce", "type": "bounce", "reason": "550 No such user"},
+		{"email": "%s", "event": "spamreport"},
+		{"email": "%s", "event": "delivered"},
+		{"email": "%s", "event": "dropped", "reason": "Invalid"},
+		{"email": "%s", "event": "dropped", "reason": "Invalid SMTPAPI header"},
+		{"email": "%s", "event": "dropped", "reason": "Unsubscribed Address"}
+	]`, bouncingUser.Email, complainingUser.Email, complainingUser.Email,
+		invalidUser.Email, droppedUser.Email, droppedUser.Email)
+
+	type test struct {
+		Token        string
+		ExpectStatus int
+	}
+
+	tests := []test{
+		{Token: "nope", ExpectStatus: http.StatusNotFound},
+		{Token: token, ExpectStatus: http.StatusOK},
+	}
+
+	for _, testCase := range tests {
+		req, err := http.NewRequest("POST", "/webhooks/email?token="+testCase.Token, strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		th.ServeHTTP(rr, req)
+
+		thelpers.AssertStatusCodeEqual(t, rr, testCase.ExpectStatus)
+	}
+
+	for _, email := range []string{bouncingUser.Email, complainingUser.Email, invalidUser.Email} {
+		suppressed, err := mail.IsSuppressed(tc, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thelpers.AssertEqual(t, suppressed, true)
+	}
+
+	// Messages dropped for reasons other than the address aren't held
+	// against it
+	suppressed, err := mail.IsSuppressed(tc, droppedUser.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thelpers.AssertEqual(t, suppressed, false)
+
+	_, rr, respData := thelpers.TestEndpoint(t, tc, th, "GET", "/users", nil, getAuthHeader(bouncingUser.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["isEmailBouncing"], true)
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", "/users", nil, getAuthHeader(complainingUser.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["isEmailBouncing"], false)
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", "/users", nil, getAuthHeader(invalidUser.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["isEmailBouncing"], true)
+
+	_, rr, respData = thelpers.TestEndpoint(t, tc, th, "GET", "/users", nil, getAuthHeader(droppedUser.Token))
+	thelpers.AssertStatusCodeEqual(t, rr, http.StatusOK)
+	thelpers.AssertEqual(t, respData["isEmailBouncing"], false)
+}
@@ -1,8 +1,10 @@
 package mail
 
 import (
+	"context"
 	"encoding/base64"
 	"net/http"
+	"strconv"
 
 	"github.com/sendgrid/sendgrid-go"
 	smail "github.com/sendgrid/sendgrid-go/helpers/mail"
@@ -15,29 +17,102 @@ import (
 // EmailMessage is a sendable email message. All of its fields
 // are strings. No additional processing or rendering is done
 // in this package.
+//
+// If UnsubscribeURL is set, the message carries RFC 8058 one-click
+// List-Unsubscribe headers pointing at it.
 type EmailMessage struct {
-	FromName      string
-	FromEmail     string
-	ToName        string
-	ToEmail       string
-	Subject       string
-	HTMLContent   string
-	TextContent   string
-	ICSAttachment string
+	FromName       string
+	FromEmail      string
+	ToName         string
+	ToEmail        string
+	Subject        string
+	HTMLContent    string
+	TextContent    string
+	ICSAttachment  string
+	UnsubscribeURL string
 }
 
 var DefaultClient Client
 
+// init selects the DefaultClient. An SMTP server takes precedence over
+// SendGrid so that local and self-hosted setups can point at their own.
+// When neither is configured, emails are only logged.
 func init() {
-	if apiKey := secrets.Get("SENDGRID_API_KEY", ""); apiKey == "" {
-		DefaultClient = NewLogger()
-	} else {
+	if host := secrets.Get("SMTP_HOST", ""); host != "" {
+		// Implicit TLS is conventionally served on a port of its own
+		security := secrets.Get("SMTP_SECURITY", SMTPStartTLS)
+		defaultPort := "587"
+		if security == SMTPImplicitTLS {
+			defaultPort = "465"
+		}
+
+		DefaultClient = NewSMTPClient(SMTPConfig{
+			Host:     host,
+			Port:     secrets.Get("SMTP_PORT", defaultPort),
+			Username: secrets.Get("SMTP_USERNAME", ""),
+			Password: secrets.Get("SMTP_PASSWORD", ""),
+			Security: security,
+		})
+	} else if apiKey := secrets.Get("SENDGRID_API_KEY", ""); apiKey != "" {
 		DefaultClient = NewClient(apiKey)
+	} else {
+		DefaultClient = NewLogger()
+	}
+
+	// Emails are only recorded when asked for: in memory for local
+	// development, or in the database as an audit log.
+	switch secrets.Get("EMAIL_OUTBOX", "") {
+	case "memory":
+		DefaultOutbox = NewMemoryOutbox(_memoryOutboxSize)
+	case "datastore":
+		DefaultOutbox = NewDatastoreOutbox()
 	}
+
+	// Sends are only spaced out when they actually go somewhere.
+	defaultRateLimit := "50"
+	if _, ok := DefaultClient.(*loggerImpl); ok {
+		defaultRateLimit = "0"
+	}
+
+	rateLimit, err := strconv.Atoi(secrets.Get("EMAIL_RATE_LIMIT", defaultRateLimit))
+	if err != nil {
+		panic(errors.E(errors.Op("mail.init"), err))
+	}
+
+	defaultLimiter = newLimiter(rateLimit)
 }
 
+const _memoryOutboxSize = 200
+
+// Send sends the given message using the DefaultClient unless its recipient
+// is on the suppression list, in which case the message is dropped.
+//
+// Transient failures are retried a few times before giving up. Every
+// message, and whether it was sent, is recorded in the DefaultOutbox if
+// there is one.
 func Send(e EmailMessage) error {
-	return DefaultClient.Send(e)
+	ctx := context.Background()
+
+	suppressed, err := IsSuppressed(ctx, e.ToEmail)
+	if err != nil {
+		// Better to risk a bounce than to lose the message
+		log.Alarm(err)
+	}
+
+	var sendErr error
+	if suppressed {
+		log.Printf("mail.Send: not sending to suppressed address '%s'", e.ToEmail)
+	} else {
+		sendErr = deliver(e)
+	}
+
+	if DefaultOutbox != nil {
+		if err := DefaultOutbox.Put(ctx, newRecord(e, sendErr, suppressed)); err != nil {
+			log.Alarm(errors.E(errors.Op("mail.Send"), err))
+		}
+	}
+
+	return sendErr
 }
 
 type Client interface {
@@ -71,14 +146,21 @@ func (s *senderImpl) Send(e EmailMessage) error {
 		email.AddAttachment(attachment)
 	}
 
+	if e.UnsubscribeURL != "" {
+		email.SetHeader("List-Unsubscribe", "<"+e.UnsubscribeURL+">")
+		email.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
 	resp, err := s.client.Send(email)
 	if err != nil {
-		return errors.E(errors.Op("mail.Send"), err)
+		return errors.E(errors.Op("mail.Send"), err, transientStatus(err))
 	}
 
 	if resp.StatusCode != http.StatusAccepted {
 		log.Print(resp.Body)
-		return errors.E(errors.Op("mail.Send"), errors.Str("received non-200 status from SendGrid"))
+		return errors.E(errors.Op("mail.Send"),
+			errors.Errorf("received %d status from SendGrid", resp.StatusCode),
+			resp.StatusCode)
 	}
 
 	return nil
@@ -0,0 +1,178 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiconvo/api/errors"
+)
+
+const (
+	// _sendWorkers is the number of messages SendMulti sends at once.
+	_sendWorkers = 8
+	// _sendAttempts is the number of times Send tries a message before
+	// giving up on a transient error.
+	_sendAttempts = 3
+	// _retryBackoff is the wait before the first retry. It doubles with
+	// every attempt after that.
+	_retryBackoff = time.Second
+)
+
+// Summary describes the outcome of a call to SendMulti.
+type Summary struct {
+	Total  int
+	Sent   int
+	Failed int
+	// Errors maps the address of each failed recipient to the last error
+	// returned while trying to send to them.
+	Errors map[string]error
+}
+
+func (s Summary) String() string {
+	if s.Failed == 0 {
+		return fmt.Sprintf("sent %d of %d", s.Sent, s.Total)
+	}
+
+	failures := make([]string, 0, len(s.Errors))
+	for addr, err := range s.Errors {
+		failures = append(failures, fmt.Sprintf("%s: %v", addr, err))
+	}
+
+	return fmt.Sprintf("sent %d of %d, failed %d (%s)",
+		s.Sent, s.Total, s.Failed, strings.Join(failures, "; "))
+}
+
+// SendMulti sends the given messages through Send using a bounded pool of
+// workers. Each message is tried independently, so one bad recipient
+// doesn't stop the rest. The returned Summary says which ones failed.
+func SendMulti(emails []EmailMessage) Summary {
+	summary := Summary{
+		Total:  len(emails),
+		Errors: map[string]error{},
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		jobs = make(chan EmailMessage)
+	)
+
+	workers := _sendWorkers
+	if len(emails) < workers {
+		workers = len(emails)
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for e := range jobs {
+				err := Send(e)
+
+				mu.Lock()
+				if err != nil {
+					summary.Failed++
+					summary.Errors[e.ToEmail] = err
+				} else {
+					summary.Sent++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range emails {
+		jobs <- emails[i]
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	return summary
+}
+
+// deliver sends e with the DefaultClient, waiting its turn under the rate
+// limit, and retries with exponential backoff if the error is transient.
+func deliver(e EmailMessage) error {
+	var err error
+
+	for attempt := 0; attempt < _sendAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(_retryBackoff << uint(attempt-1))
+		}
+
+		defaultLimiter.Wait()
+
+		err = DefaultClient.Send(e)
+		if err == nil || !isTransient(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// isTransient reports whether a send that failed with err is worth trying
+// again. Clients flag temporary failures, such as being rate limited by the
+// provider or a dropped connection, with one of the status codes below.
+func isTransient(err error) bool {
+	if e, ok := err.(errors.ClientReporter); ok {
+		switch e.StatusCode() {
+		case http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+	}
+
+	return false
+}
+
+// transientStatus returns the status code a client should attach to err
+// when it was caused by the network or by a temporary SMTP rejection, which
+// uses 4xx reply codes. It returns http.StatusInternalServerError otherwise.
+func transientStatus(err error) int {
+	if _, ok := err.(net.Error); ok {
+		return http.StatusServiceUnavailable
+	}
+
+	if e, ok := err.(*textproto.Error); ok && e.Code >= 400 && e.Code < 500 {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+// limiter spaces out sends so that providers don't throttle us. A nil
+// limiter doesn't limit anything.
+type limiter struct {
+	ticker *time.Ticker
+}
+
+var defaultLimiter *limiter
+
+// newLimiter returns a limiter that allows perSecond sends every second, or
+// nil if perSecond isn't positive.
+func newLimiter(perSecond int) *limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &limiter{ticker: time.NewTicker(time.Second / time.Duration(perSecond))}
+}
+
+// Wait blocks until the next send is allowed.
+func (l *limiter) Wait() {
+	if l == nil {
+		return
+	}
+
+	<-l.ticker.C
+}
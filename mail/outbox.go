@@ -0,0 +1,249 @@
+package mail
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/hiconvo/api/db"
+	"github.com/hiconvo/api/errors"
+)
+
+// Statuses of a Record.
+const (
+	StatusSent       = "sent"
+	StatusFailed     = "failed"
+	StatusSuppressed = "suppressed"
+)
+
+// Record is a copy of an email that was handed to Send along with the
+// result of sending it.
+type Record struct {
+	Key            *datastore.Key `json:"-"              datastore:"__key__"`
+	ID             string         `json:"id"             datastore:"-"`
+	FromName       string         `json:"fromName"       datastore:",noindex"`
+	FromEmail      string         `json:"fromEmail"      datastore:",noindex"`
+	ToName         string         `json:"toName"         datastore:",noindex"`
+	ToEmail        string         `json:"toEmail"`
+	Subject        string         `json:"subject"        datastore:",noindex"`
+	HTMLContent    string         `json:"htmlContent"    datastore:",noindex"`
+	TextContent    string         `json:"textContent"    datastore:",noindex"`
+	ICSAttachment  string         `json:"icsAttachment"  datastore:",noindex"`
+	UnsubscribeURL string         `json:"unsubscribeUrl" datastore:",noindex"`
+	Status         string         `json:"status"`
+	Error          string         `json:"error"          datastore:",noindex"`
+	CreatedAt      time.Time      `json:"createdAt"`
+}
+
+func (r *Record) LoadKey(k *datastore.Key) error {
+	r.Key = k
+	r.ID = k.Encode()
+	return nil
+}
+
+func (r *Record) Save() ([]datastore.Property, error) {
+	return datastore.SaveStruct(r)
+}
+
+func (r *Record) Load(ps []datastore.Property) error {
+	return datastore.LoadStruct(r, ps)
+}
+
+// Outbox is a log of the emails handed to Send. DefaultOutbox is nil when
+// emails should not be logged.
+type Outbox interface {
+	Put(ctx context.Context, r *Record) error
+	Get(ctx context.Context, id string) (*Record, error)
+	GetAll(ctx context.Context, offset, limit int) ([]*Record, error)
+}
+
+var DefaultOutbox Outbox
+
+// IsMemoryOutbox returns true if the DefaultOutbox keeps emails in memory,
+// which is only meant for local development.
+func IsMemoryOutbox() bool {
+	_, ok := DefaultOutbox.(*memoryOutbox)
+	return ok
+}
+
+// newRecord returns a record of e with its links and reply addresses
+// redacted. Nearly every email carries a magic link or a reply address that
+// acts on behalf of its recipient, so none of them are kept.
+func newRecord(e EmailMessage, sendErr error, suppressed bool) *Record {
+	r := &Record{
+		FromName:       e.FromName,
+		FromEmail:      redact(e.FromEmail),
+		ToName:         e.ToName,
+		ToEmail:        e.ToEmail,
+		Subject:        e.Subject,
+		HTMLContent:    redact(e.HTMLContent),
+		TextContent:    redact(e.TextContent),
+		ICSAttachment:  redact(e.ICSAttachment),
+		UnsubscribeURL: redact(e.UnsubscribeURL),
+		Status:         StatusSent,
+		CreatedAt:      time.Now(),
+	}
+
+	if suppressed {
+		r.Status = StatusSuppressed
+	} else if sendErr != nil {
+		r.Status = StatusFailed
+		r.Error = sendErr.Error()
+	}
+
+	return r
+}
+
+const _redacted = "[redacted]"
+
+var (
+	_linkPattern         = regexp.MustCompile(`https?://[^\s"'<>]+`)
+	_replyAddressPattern = regexp.MustCompile(`-[0-9a-z]+\.[0-9a-z]+\.[0-9a-f]+@`)
+)
+
+// redact replaces everything after the first path segment of the links in
+// s, where magic links keep their tokens, and the tokens of reply addresses.
+func redact(s string) string {
+	s = _linkPattern.ReplaceAllStringFunc(s, func(link string) string {
+		u, err := url.Parse(link)
+		if err != nil {
+			return _redacted
+		}
+
+		segments := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		if len(segments) < 2 && u.RawQuery == "" {
+			return link
+		}
+
+		if segments[0] == "" {
+			return u.Scheme + "://" + u.Host + "/" + _redacted
+		}
+
+		return u.Scheme + "://" + u.Host + "/" + segments[0] + "/" + _redacted
+	})
+
+	return _replyAddressPattern.ReplaceAllString(s, "-"+_redacted+"@")
+}
+
+// memoryOutbox keeps the most recent records in memory. It's meant for
+// local development, where there is a single instance and losing the log
+// on restart doesn't matter.
+type memoryOutbox struct {
+	mu      sync.Mutex
+	records []*Record
+	size    int
+	nextID  int
+}
+
+// NewMemoryOutbox returns an Outbox that keeps at most size records.
+func NewMemoryOutbox(size int) Outbox {
+	return &memoryOutbox{size: size}
+}
+
+func (m *memoryOutbox) Put(ctx context.Context, r *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	r.ID = strconv.Itoa(m.nextID)
+
+	// Newest first
+	m.records = append([]*Record{r}, m.records...)
+	if len(m.records) > m.size {
+		m.records = m.records[:m.size]
+	}
+
+	return nil
+}
+
+func (m *memoryOutbox) Get(ctx context.Context, id string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.records {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+
+	return nil, errors.E(errors.Op("mail.Get"), http.StatusNotFound, errors.Str("record not found"))
+}
+
+func (m *memoryOutbox) GetAll(ctx context.Context, offset, limit int) ([]*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if offset >= len(m.records) {
+		return []*Record{}, nil
+	}
+
+	end := len(m.records)
+	if limit >= 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	records := make([]*Record, end-offset)
+	copy(records, m.records[offset:end])
+
+	return records, nil
+}
+
+// datastoreOutbox persists records so that there is an audit trail of
+// what was emailed in production.
+type datastoreOutbox struct{}
+
+// NewDatastoreOutbox returns an Outbox backed by the database.
+func NewDatastoreOutbox() Outbox {
+	return &datastoreOutbox{}
+}
+
+func (d *datastoreOutbox) Put(ctx context.Context, r *Record) error {
+	key, err := db.DefaultClient.Put(ctx, datastore.IncompleteKey("SentEmail", nil), r)
+	if err != nil {
+		return errors.E(errors.Op("mail.Put"), err)
+	}
+
+	return r.LoadKey(key)
+}
+
+func (d *datastoreOutbox) Get(ctx context.Context, id string) (*Record, error) {
+	op := errors.Op("mail.Get")
+
+	key, err := datastore.DecodeKey(id)
+	if err != nil || key.Kind != "SentEmail" {
+		return nil, errors.E(op, http.StatusNotFound, errors.Str("record not found"))
+	}
+
+	var r Record
+	if err := db.DefaultClient.Get(ctx, key, &r); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, errors.E(op, http.StatusNotFound, err)
+		}
+
+		return nil, errors.E(op, err)
+	}
+
+	return &r, nil
+}
+
+func (d *datastoreOutbox) GetAll(ctx context.Context, offset, limit int) ([]*Record, error) {
+	records := []*Record{}
+
+	q := datastore.NewQuery("SentEmail").
+		Order("-CreatedAt").
+		Offset(offset).
+		Limit(limit)
+
+	if _, err := db.DefaultClient.GetAll(ctx, q, &records); err != nil {
+		return records, errors.E(errors.Op("mail.GetAll"), err)
+	}
+
+	return records, nil
+}
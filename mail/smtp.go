@@ -0,0 +1,277 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/utils/random"
+)
+
+// SMTP connection security modes.
+const (
+	// SMTPStartTLS connects in plain text and upgrades the connection
+	// with STARTTLS. The server must support it.
+	SMTPStartTLS = "starttls"
+	// SMTPImplicitTLS connects over TLS from the start, usually on port 465.
+	SMTPImplicitTLS = "tls"
+	// SMTPPlain never encrypts the connection. It is only suitable for
+	// local mail catchers.
+	SMTPPlain = "none"
+)
+
+const _smtpTimeout = 10 * time.Second
+
+// SMTPConfig holds the settings needed to deliver mail through an SMTP
+// server. If Username is empty, no authentication is attempted.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Security string
+}
+
+type smtpImpl struct {
+	config SMTPConfig
+}
+
+// NewSMTPClient returns a Client that delivers mail through the SMTP
+// server described by the given config.
+func NewSMTPClient(config SMTPConfig) Client {
+	if config.Security == "" {
+		config.Security = SMTPStartTLS
+	}
+
+	return &smtpImpl{config: config}
+}
+
+// Send sends the given EmailMessage.
+func (s *smtpImpl) Send(e EmailMessage) error {
+	op := errors.Op("mail.Send")
+
+	msg, err := buildMIMEMessage(e, time.Now())
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	c, err := s.dial()
+	if err != nil {
+		return errors.E(op, err, transientStatus(err))
+	}
+	defer c.Close()
+
+	if s.config.Username != "" {
+		auth := smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+		if err := c.Auth(auth); err != nil {
+			return errors.E(op, err, transientStatus(err))
+		}
+	}
+
+	if err := c.Mail(e.FromEmail); err != nil {
+		return errors.E(op, err, transientStatus(err))
+	}
+
+	if err := c.Rcpt(e.ToEmail); err != nil {
+		return errors.E(op, err, transientStatus(err))
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return errors.E(op, err, transientStatus(err))
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return errors.E(op, err, transientStatus(err))
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.E(op, err, transientStatus(err))
+	}
+
+	if err := c.Quit(); err != nil {
+		return errors.E(op, err, transientStatus(err))
+	}
+
+	return nil
+}
+
+func (s *smtpImpl) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.config.Host, s.config.Port)
+	tlsConfig := &tls.Config{ServerName: s.config.Host}
+	dialer := &net.Dialer{Timeout: _smtpTimeout}
+
+	if s.config.Security == SMTPImplicitTLS {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := smtp.NewClient(conn, s.config.Host)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if s.config.Security == SMTPStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, errors.Errorf("%s does not support STARTTLS", addr)
+		}
+
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// buildMIMEMessage renders the given EmailMessage as a multipart/alternative
+// message with text and HTML bodies. If there is an ICS attachment, the
+// message is wrapped in multipart/mixed with the attachment alongside.
+func buildMIMEMessage(e EmailMessage, date time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	alt := multipart.NewWriter(&body)
+
+	if err := writeQuotedPrintablePart(alt, "text/plain; charset=utf-8", e.TextContent); err != nil {
+		return nil, err
+	}
+
+	if err := writeQuotedPrintablePart(alt, "text/html; charset=utf-8", e.HTMLContent); err != nil {
+		return nil, err
+	}
+
+	if err := alt.Close(); err != nil {
+		return nil, err
+	}
+
+	contentType := "multipart/alternative; boundary=" + alt.Boundary()
+
+	if e.ICSAttachment != "" {
+		var mixedBody bytes.Buffer
+		mixed := multipart.NewWriter(&mixedBody)
+
+		part, err := mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := part.Write(body.Bytes()); err != nil {
+			return nil, err
+		}
+
+		part, err = mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {`text/calendar; charset=utf-8; name="event.ics"`},
+			"Content-Disposition":       {`attachment; filename="event.ics"`},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := part.Write(wrapBase64(e.ICSAttachment)); err != nil {
+			return nil, err
+		}
+
+		if err := mixed.Close(); err != nil {
+			return nil, err
+		}
+
+		body = mixedBody
+		contentType = "multipart/mixed; boundary=" + mixed.Boundary()
+	}
+
+	from := netmail.Address{Name: e.FromName, Address: e.FromEmail}
+	to := netmail.Address{Name: e.ToName, Address: e.ToEmail}
+
+	var msg bytes.Buffer
+	writeHeader(&msg, "From", from.String())
+	writeHeader(&msg, "To", to.String())
+	writeHeader(&msg, "Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	writeHeader(&msg, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&msg, "Message-ID", fmt.Sprintf("<%s@%s>", random.Token(), getDomain(e.FromEmail)))
+	if e.UnsubscribeURL != "" {
+		writeHeader(&msg, "List-Unsubscribe", "<"+e.UnsubscribeURL+">")
+		writeHeader(&msg, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	writeHeader(&msg, "MIME-Version", "1.0")
+	writeHeader(&msg, "Content-Type", contentType)
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+func writeQuotedPrintablePart(w *multipart.Writer, contentType, content string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+
+	return qp.Close()
+}
+
+// writeHeader writes a single header line. Line breaks are stripped from
+// the value so that user supplied content can't inject headers.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+}
+
+// wrapBase64 encodes content as base64 in lines of 76 characters as
+// required by RFC 2045.
+func wrapBase64(content string) []byte {
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+
+	return buf.Bytes()
+}
+
+func getDomain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return email[i+1:]
+	}
+
+	return "localhost"
+}
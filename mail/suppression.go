@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/hiconvo/api/db"
+	"github.com/hiconvo/api/errors"
+)
+
+// Suppression is an address that should no longer be emailed, usually
+// because mail to it bounced or its owner reported a message as spam.
+type Suppression struct {
+	Key       *datastore.Key `datastore:"__key__"`
+	Email     string
+	Reason    string `datastore:",noindex"`
+	CreatedAt time.Time
+}
+
+// Suppress adds the given address to the suppression list.
+func Suppress(ctx context.Context, email, reason string) error {
+	femail := strings.ToLower(email)
+
+	s := Suppression{
+		Key:       getSuppressionKey(femail),
+		Email:     femail,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := db.DefaultClient.Put(ctx, s.Key, &s); err != nil {
+		return errors.E(errors.Op("mail.Suppress"), err)
+	}
+
+	return nil
+}
+
+// Unsuppress removes the given address from the suppression list, if
+// it's there.
+func Unsuppress(ctx context.Context, email string) error {
+	if err := db.DefaultClient.Delete(ctx, getSuppressionKey(strings.ToLower(email))); err != nil {
+		return errors.E(errors.Op("mail.Unsuppress"), err)
+	}
+
+	return nil
+}
+
+// IsSuppressed returns true if the given address is on the suppression list.
+func IsSuppressed(ctx context.Context, email string) (bool, error) {
+	var s Suppression
+	err := db.DefaultClient.Get(ctx, getSuppressionKey(strings.ToLower(email)), &s)
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	} else if err != nil {
+		return false, errors.E(errors.Op("mail.IsSuppressed"), err)
+	}
+
+	return true, nil
+}
+
+func getSuppressionKey(femail string) *datastore.Key {
+	return datastore.NameKey("Suppression", femail, nil)
+}
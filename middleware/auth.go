@@ -55,3 +55,16 @@ func GetAuthToken(h http.Header) (string, bool) {
 
 	return "", false
 }
+
+// WithAdmin only allows admins through. It must be used after WithUser.
+// Everyone else gets a 404 so as not to reveal that the endpoint exists.
+func WithAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := UserFromContext(r.Context()); !u.IsAdmin {
+			bjson.HandleError(w, errors.E(errors.Op("middleware.WithAdmin"), http.StatusNotFound, errors.Str("NotAdmin")))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -8,8 +8,8 @@ import (
 
 var corsHandler = handlers.CORS(
 	handlers.AllowedOrigins([]string{"*"}),
-	handlers.AllowedMethods([]string{"GET", "PATCH", "POST", "DELETE"}),
-	handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+	handlers.AllowedMethods([]string{"GET", "PATCH", "POST", "PUT", "DELETE"}),
+	handlers.AllowedHeaders([]string{"Content-Type", "Content-Range", "Authorization"}),
 )
 
 // WithCORS adds OPTIONS endpoints and validates CORS permissions and validation
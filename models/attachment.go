@@ -0,0 +1,84 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hiconvo/api/errors"
+)
+
+// Limits on what can be sent with a single message.
+const (
+	MaxPhotosPerMessage      = 10
+	MaxAttachmentsPerMessage = 5
+	// MaxPhotoSize and MaxAttachmentSize are in bytes, before encoding.
+	MaxPhotoSize      = 10 << 20
+	MaxAttachmentSize = 10 << 20
+)
+
+// _attachmentTypes are the content types of the files other than photos
+// that can be sent with messages.
+var _attachmentTypes = map[string]struct{}{
+	"application/pdf":    {},
+	"text/plain":         {},
+	"text/csv":           {},
+	"application/rtf":    {},
+	"application/msword": {},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   {},
+	"application/vnd.ms-excel":                                                  {},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {},
+	"application/vnd.ms-powerpoint":                                             {},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {},
+	"application/vnd.oasis.opendocument.text":                                   {},
+	"application/vnd.oasis.opendocument.spreadsheet":                            {},
+	"application/vnd.oasis.opendocument.presentation":                           {},
+}
+
+// Attachment is a file other than a photo that was sent with a message.
+// The file is kept in storage under Key, along with the original name and
+// content type, so that it downloads as it was uploaded. URL is only set
+// once the attachment is signed by SignMessageURLs.
+type Attachment struct {
+	Key         string `json:"-"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        int    `json:"size"`
+	URL         string `json:"url" datastore:"-"`
+}
+
+// NewAttachment returns an attachment for the file that was stored under
+// the given key.
+func NewAttachment(key, name, contentType string, size int) *Attachment {
+	return &Attachment{
+		Key:         key,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+	}
+}
+
+// ValidateAttachment returns a client error if a file with the given name,
+// content type, and size can't be attached to a message.
+func ValidateAttachment(name, contentType string, size int) error {
+	op := errors.Op("models.ValidateAttachment")
+
+	if strings.TrimSpace(name) == "" || len(name) > 255 {
+		return errors.E(op, errors.Str("InvalidName"),
+			map[string]string{"attachments": "Attachments must have a name"},
+			http.StatusBadRequest)
+	}
+
+	if _, ok := _attachmentTypes[contentType]; !ok {
+		return errors.E(op, errors.Str("InvalidContentType"),
+			map[string]string{"attachments": "This type of file can't be attached"},
+			http.StatusBadRequest)
+	}
+
+	if size > MaxAttachmentSize {
+		return errors.E(op, errors.Str("TooLarge"),
+			map[string]string{"attachments": "Attachments must be smaller than 10 MB"},
+			http.StatusBadRequest)
+	}
+
+	return nil
+}
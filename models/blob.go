@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/hiconvo/api/db"
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/storage"
+)
+
+// CollectBlobGarbage deletes the files in storage that nothing refers to
+// anymore, once they are older than the grace period. In a dry run, it only
+// reports what would be deleted.
+func CollectBlobGarbage(ctx context.Context, dryRun bool) (*storage.GarbageReport, error) {
+	op := errors.Op("models.CollectBlobGarbage")
+
+	keys, err := GetReferencedBlobKeys(ctx)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	report, err := storage.DefaultClient.CollectGarbage(ctx, keys, _blobGracePeriod, dryRun)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return report, nil
+}
+
+// GetReferencedBlobKeys returns the storage keys of every file that is still
+// in use: the photos and attachments of messages, avatars, and uploads that
+// haven't been attached yet. Messages of threads and events that have been
+// deleted are left behind, so their files only count while the thread or
+// event exists.
+func GetReferencedBlobKeys(ctx context.Context) ([]string, error) {
+	op := errors.Op("models.GetReferencedBlobKeys")
+
+	parents := make(map[string]struct{})
+	for _, kind := range []string{"Thread", "Event"} {
+		keys, err := db.DefaultClient.GetAll(ctx, datastore.NewQuery(kind).KeysOnly(), nil)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		for _, key := range keys {
+			parents[key.Encode()] = struct{}{}
+		}
+	}
+
+	var blobKeys []string
+
+	messages := db.DefaultClient.Run(ctx, datastore.NewQuery("Message"))
+	for {
+		var m Message
+		_, err := messages.Next(&m)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		if _, ok := parents[m.ParentID]; !ok {
+			continue
+		}
+
+		blobKeys = append(blobKeys, m.PhotoKeys...)
+		for _, a := range m.Attachments {
+			blobKeys = append(blobKeys, a.Key)
+		}
+	}
+
+	users := db.DefaultClient.Run(ctx, datastore.NewQuery("User"))
+	for {
+		var u User
+		_, err := users.Next(&u)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		if u.Avatar != "" {
+			blobKeys = append(blobKeys, storage.DefaultClient.GetKeyFromAvatarURL(u.Avatar))
+		}
+	}
+
+	uploads := db.DefaultClient.Run(ctx, datastore.NewQuery("Upload"))
+	for {
+		var up Upload
+		_, err := uploads.Next(&up)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		blobKeys = append(blobKeys, up.BlobKey)
+	}
+
+	return blobKeys, nil
+}
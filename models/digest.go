@@ -2,12 +2,23 @@ package models
 
 import (
 	"context"
+	"time"
 
 	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
 
 	"github.com/hiconvo/api/db"
 )
 
+const (
+	// _digestMessagesPerItem caps how many of the most recent unread
+	// messages of a thread or event are included in a digest.
+	_digestMessagesPerItem = 5
+	// _digestLookback bounds how far back the first digest of a user looks
+	// for messages. It is long enough to cover a weekly digest.
+	_digestLookback = 7 * 24 * time.Hour
+)
+
 type DigestItem struct {
 	ParentID *datastore.Key
 	Name     string
@@ -15,8 +26,9 @@ type DigestItem struct {
 }
 
 type Digestable interface {
-	GetKey() *datastore.Key
+	Readable
 	GetName() string
+	WasEmailedTo(m *Message, u *User) bool
 }
 
 type DigestError struct{}
@@ -25,10 +37,105 @@ func (e *DigestError) Error() string {
 	return "Nothing to digest"
 }
 
-func GenerateDigestList(ctx context.Context, digestables []Digestable, u *User) ([]DigestItem, error) {
+// MigrateDigestSchedules saves every user again so that they have a
+// NextDigestAt, which the hourly digest task looks for. It returns how many
+// users were saved and can be run again if it times out.
+func MigrateDigestSchedules(ctx context.Context) (int, error) {
+	var n int
+
+	users := db.DefaultClient.Run(ctx, datastore.NewQuery("User"))
+	for {
+		var u User
+		_, err := users.Next(&u)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return n, err
+		}
+
+		if !u.NextDigestAt.IsZero() {
+			continue
+		}
+
+		if _, err := db.DefaultClient.Put(ctx, u.Key, &u); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// GetDigestSince returns the time after which messages are new to the
+// user's next digest, which is when their last digest was sent.
+func (u *User) GetDigestSince(now time.Time) time.Time {
+	earliest := now.Add(-_digestLookback)
+	if u.LastDigestAt.Before(earliest) {
+		return earliest
+	}
+
+	return u.LastDigestAt
+}
+
+// GetDigestablesByUser returns the threads and events of which the user is
+// a member that have had messages since the given time and that the user
+// hasn't read. The threads and events are not hydrated apart from the
+// user's read cursors.
+func GetDigestablesByUser(ctx context.Context, u *User, since time.Time) ([]Digestable, error) {
+	var threads []*Thread
+	q := datastore.NewQuery("Thread").
+		Filter("UserKeys =", u.Key).
+		Filter("UpdatedAt >", since)
+	if _, err := db.DefaultClient.GetAll(ctx, q, &threads); err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	q = datastore.NewQuery("Event").
+		Filter("UserKeys =", u.Key).
+		Filter("UpdatedAt >", since)
+	if _, err := db.DefaultClient.GetAll(ctx, q, &events); err != nil {
+		return nil, err
+	}
+
+	readables := make([]Readable, 0, len(events)+len(threads))
+	for i := range events {
+		readables = append(readables, events[i])
+	}
+	for i := range threads {
+		readables = append(readables, threads[i])
+	}
+
+	readUserKeys := make([][]*datastore.Key, len(readables))
+	for i := range readables {
+		readUserKeys[i] = []*datastore.Key{u.Key}
+	}
+
+	if err := loadReads(ctx, readables, readUserKeys); err != nil {
+		return nil, err
+	}
+
+	var digestables []Digestable
+	for i := range events {
+		if !IsRead(events[i], u.Key) {
+			digestables = append(digestables, events[i])
+		}
+	}
+
+	for i := range threads {
+		if !IsRead(threads[i], u.Key) {
+			digestables = append(digestables, threads[i])
+		}
+	}
+
+	return digestables, nil
+}
+
+func GenerateDigestList(ctx context.Context, digestables []Digestable, u *User, since time.Time) ([]DigestItem, error) {
 	var digest []DigestItem
 	for i := range digestables {
-		item, err := GenerateDigestItem(ctx, digestables[i], u)
+		item, err := GenerateDigestItem(ctx, digestables[i], u, since)
 		if err != nil {
 			switch err.(type) {
 			case *DigestError:
@@ -44,45 +151,61 @@ func GenerateDigestList(ctx context.Context, digestables []Digestable, u *User)
 	return digest, nil
 }
 
-func GenerateDigestItem(ctx context.Context, d Digestable, u *User) (DigestItem, error) {
-	messages, err := GetMessagesByKey(ctx, d.GetKey())
-	if err != nil {
+// GenerateDigestItem returns the most recent messages of d that were sent
+// after since and that the user hasn't read, oldest first. At most
+// _digestMessagesPerItem messages are included. The user's read cursor
+// for d must have been loaded.
+func GenerateDigestItem(ctx context.Context, d Digestable, u *User, since time.Time) (DigestItem, error) {
+	if readAt := GetReadTime(d, u.Key); readAt.After(since) {
+		since = readAt
+	}
+
+	var messages []*Message
+	q := datastore.NewQuery("Message").
+		Filter("ParentKey =", d.GetKey()).
+		Filter("Timestamp >", since).
+		Order("-Timestamp").
+		Limit(_digestMessagesPerItem)
+	if _, err := db.DefaultClient.GetAll(ctx, q, &messages); err != nil {
 		return DigestItem{}, err
 	}
 
+	// Walk backwards so that the messages end up oldest first. Messages
+	// that were emailed to the user already are left out.
 	var unread []*Message
-	for j := range messages {
-		if !IsRead(messages[j], u.Key) {
-			unread = append(unread, messages[j])
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].UserKey.Equal(u.Key) {
+			continue
 		}
+
+		if d.WasEmailedTo(messages[i], u) {
+			continue
+		}
+
+		unread = append(unread, messages[i])
 	}
 
-	if len(unread) > 0 {
-		return DigestItem{
-			ParentID: d.GetKey(),
-			Name:     d.GetName(),
-			Messages: unread,
-		}, nil
+	if len(unread) == 0 {
+		return DigestItem{}, &DigestError{}
 	}
 
-	return DigestItem{}, &DigestError{}
-}
+	userKeys := make([]*datastore.Key, len(unread))
+	for i := range unread {
+		userKeys[i] = unread[i].UserKey
+	}
 
-func MarkDigestedMessagesAsRead(ctx context.Context, digestList []DigestItem, user *User) error {
-	var messages []*Message
-	var keys []*datastore.Key
-	for i := range digestList {
-		for j := range digestList[i].Messages {
-			MarkAsRead(digestList[i].Messages[j], user.Key)
-			messages = append(messages, digestList[i].Messages[j])
-			keys = append(keys, digestList[i].Messages[j].Key)
-		}
+	users := make([]*User, len(userKeys))
+	if err := db.DefaultClient.GetMulti(ctx, userKeys, users); err != nil {
+		return DigestItem{}, err
 	}
 
-	_, err := db.DefaultClient.PutMulti(ctx, keys, messages)
-	if err != nil {
-		return err
+	for i := range unread {
+		unread[i].User = MapUserToUserPartial(users[i])
 	}
 
-	return nil
+	return DigestItem{
+		ParentID: d.GetKey(),
+		Name:     d.GetName(),
+		Messages: unread,
+	}, nil
 }
@@ -9,10 +9,12 @@ import (
 	"cloud.google.com/go/datastore"
 	ics "github.com/arran4/golang-ical"
 	"github.com/gosimple/slug"
+	"google.golang.org/api/iterator"
 
 	"github.com/hiconvo/api/db"
 	"github.com/hiconvo/api/errors"
 	"github.com/hiconvo/api/queue"
+	"github.com/hiconvo/api/utils/i18n"
 	"github.com/hiconvo/api/utils/magic"
 	"github.com/hiconvo/api/utils/random"
 )
@@ -36,12 +38,16 @@ type Event struct {
 	Lng             float64          `json:"lng"      datastore:",noindex"`
 	Name            string           `json:"name"     datastore:",noindex"`
 	Description     string           `json:"description"  datastore:",noindex"`
-	Timestamp       time.Time        `json:"timestamp"    datastore:",noindex"`
+	Timestamp       time.Time        `json:"timestamp"`
 	UTCOffset       int              `json:"-"        datastore:",noindex"`
 	UserReads       []*UserPartial   `json:"reads"    datastore:"-"`
-	Reads           []*Read          `json:"-"        datastore:",noindex"`
+	Reads           []*Read          `json:"-"        datastore:"-"`
 	CreatedAt       time.Time        `json:"createdAt"`
+	UpdatedAt       time.Time        `json:"updatedAt"`
 	GuestsCanInvite bool             `json:"guestsCanInvite"`
+	UnreadCount     int              `json:"unreadCount" datastore:"-"`
+	StorageUsed     int              `json:"-"        datastore:",noindex"`
+	legacyReads     []*Read
 }
 
 func NewEvent(
@@ -145,7 +151,7 @@ func (e *Event) Save() ([]datastore.Property, error) {
 func (e *Event) Load(ps []datastore.Property) error {
 	if err := datastore.LoadStruct(e, ps); err != nil {
 		if mismatch, ok := err.(*datastore.ErrFieldMismatch); ok {
-			if mismatch.FieldName != "GuestsCanInvite" {
+			if mismatch.FieldName != "GuestsCanInvite" && mismatch.FieldName != "Reads" {
 				return err
 			}
 		} else {
@@ -153,6 +159,12 @@ func (e *Event) Load(ps []datastore.Property) error {
 		}
 	}
 
+	for _, p := range ps {
+		if p.Name == "Reads" {
+			e.legacyReads = loadLegacyReads(p.Value)
+		}
+	}
+
 	return nil
 }
 
@@ -161,6 +173,10 @@ func (e *Event) Commit(ctx context.Context) error {
 		e.CreatedAt = time.Now()
 	}
 
+	if e.UpdatedAt.IsZero() {
+		e.UpdatedAt = e.CreatedAt
+	}
+
 	key, err := db.DefaultClient.Put(ctx, e.Key, e)
 	if err != nil {
 		return err
@@ -176,8 +192,14 @@ func (e *Event) CommitWithTransaction(tx db.Transaction) (*datastore.PendingKey,
 	return tx.Put(e.Key, e)
 }
 
+// Delete deletes the event along with its read cursors.
 func (e *Event) Delete(ctx context.Context) error {
-	if err := db.DefaultClient.Delete(ctx, e.Key); err != nil {
+	keys, err := getReadKeysByParent(ctx, e.Key)
+	if err != nil {
+		return err
+	}
+
+	if err := db.DefaultClient.DeleteMulti(ctx, append(keys, e.Key)); err != nil {
 		return err
 	}
 	return nil
@@ -195,13 +217,19 @@ func (e *Event) GetKey() *datastore.Key {
 	return e.Key
 }
 
+func (e *Event) GetUpdatedAt() time.Time {
+	return e.UpdatedAt
+}
+
 func (e *Event) GetName() string {
 	return e.Name
 }
 
-func (e *Event) GetFormatedTime() string {
+// GetFormatedTime returns the time of the event in its own time zone,
+// formatted for the given locale.
+func (e *Event) GetFormatedTime(locale string) string {
 	loc := time.FixedZone("Given", e.UTCOffset)
-	return e.Timestamp.In(loc).Format("Monday, January 2 @ 3:04 PM")
+	return i18n.FormatTime(locale, e.Timestamp.In(loc))
 }
 
 func (e *Event) HasUser(u *User) bool {
@@ -314,7 +342,9 @@ func (e *Event) AddRSVP(u *User) error {
 
 	e.RSVPKeys = append(e.RSVPKeys, u.Key)
 	e.RSVPs = append(e.RSVPs, MapUserToUserPartial(u))
-	e.SetReads([]*Read{})
+
+	// The RSVP is news to the other members, so the event becomes unread
+	e.UpdatedAt = time.Now()
 
 	return nil
 }
@@ -386,6 +416,25 @@ func (e *Event) SendUpdatedInvitesAsync(ctx context.Context) error {
 	})
 }
 
+// SendMentions emails the latest message of the event to the members it
+// mentions.
+func (e *Event) SendMentions(ctx context.Context) error {
+	messages, err := GetMessagesByEvent(ctx, e, &Pagination{Size: -1})
+	if err != nil {
+		return err
+	}
+
+	return sendEventMentions(e, messages)
+}
+
+func (e *Event) SendMentionsAsync(ctx context.Context) error {
+	return queue.PutEmail(ctx, queue.EmailPayload{
+		Type:   queue.Event,
+		Action: queue.SendMentions,
+		IDs:    []string{e.ID},
+	})
+}
+
 func (e *Event) SendInviteToUser(ctx context.Context, user *User) error {
 	return sendEventInvitation(e, user)
 }
@@ -398,20 +447,18 @@ func (e *Event) IsInFuture() bool {
 	return e.Timestamp.After(time.Now())
 }
 
-func (e *Event) IsUpcoming() bool {
-	start, err := time.ParseDuration("6h")
-	if err != nil {
-		return false
-	}
-	end, err := time.ParseDuration("30h")
-	if err != nil {
-		return false
-	}
-
-	windowStart := time.Now().Add(start)
-	windowEnd := time.Now().Add(end)
+// Events are upcoming when they start between _upcomingStart and
+// _upcomingEnd from now. At most _maxUpcomingEvents of them are included in
+// a digest.
+const (
+	_upcomingStart     = 6 * time.Hour
+	_upcomingEnd       = 30 * time.Hour
+	_maxUpcomingEvents = 10
+)
 
-	return e.Timestamp.After(windowStart) && e.Timestamp.Before(windowEnd)
+func (e *Event) IsUpcoming() bool {
+	now := time.Now()
+	return e.Timestamp.After(now.Add(_upcomingStart)) && e.Timestamp.Before(now.Add(_upcomingEnd))
 }
 
 func (e *Event) GetICS() string {
@@ -452,20 +499,84 @@ func GetEventByID(ctx context.Context, id string) (Event, error) {
 func GetUnhydratedEventsByUser(ctx context.Context, u *User, p *Pagination) ([]*Event, error) {
 	var events []*Event
 
-	q := datastore.NewQuery("Event").
+	q, err := p.apply(datastore.NewQuery("Event").
 		Filter("UserKeys =", u.Key).
-		Order("-CreatedAt").
-		Offset(p.Offset()).
-		Limit(p.Limit())
-
-	_, err := db.DefaultClient.GetAll(ctx, q, &events)
+		Order("-CreatedAt"))
 	if err != nil {
 		return events, err
 	}
 
+	it := db.DefaultClient.Run(ctx, q)
+	for {
+		var e Event
+		_, err := it.Next(&e)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return events, err
+		}
+
+		events = append(events, &e)
+	}
+
+	if err := p.setNext(it, len(events)); err != nil {
+		return events, err
+	}
+
 	return events, nil
 }
 
+// GetUpcomingEventsByUser returns the user's events that are upcoming,
+// soonest first.
+func GetUpcomingEventsByUser(ctx context.Context, u *User) ([]*Event, error) {
+	now := time.Now()
+
+	var events []*Event
+	q := datastore.NewQuery("Event").
+		Filter("UserKeys =", u.Key).
+		Filter("Timestamp >", now.Add(_upcomingStart)).
+		Filter("Timestamp <", now.Add(_upcomingEnd)).
+		Order("Timestamp").
+		Limit(_maxUpcomingEvents)
+	if _, err := db.DefaultClient.GetAll(ctx, q, &events); err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
+
+// MigrateEventTimestamps saves the events that haven't happened yet again so
+// that their Timestamp, which used to be unindexed, is indexed and they are
+// found by GetUpcomingEventsByUser. Events with legacy reads are left to
+// MigrateReads, which saves them as well. It returns how many were saved.
+func MigrateEventTimestamps(ctx context.Context) (int, error) {
+	var n int
+
+	now := time.Now()
+	events := db.DefaultClient.Run(ctx, datastore.NewQuery("Event"))
+	for {
+		var e Event
+		_, err := events.Next(&e)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return n, err
+		}
+
+		if e.Timestamp.Before(now) || e.legacyReads != nil {
+			continue
+		}
+
+		if _, err := db.DefaultClient.Put(ctx, e.Key, &e); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
 func GetEventsByUser(ctx context.Context, u *User, p *Pagination) ([]*Event, error) {
 	// Get all of the events of which the user is a member
 	events, err := GetUnhydratedEventsByUser(ctx, u, p)
@@ -489,6 +600,18 @@ func GetEventsByUser(ctx context.Context, u *User, p *Pagination) ([]*Event, err
 		return events, err
 	}
 
+	// We get the members' read cursors in one go too.
+	readables := make([]Readable, len(events))
+	readUserKeys := make([][]*datastore.Key, len(events))
+	for i := range events {
+		readables[i] = events[i]
+		readUserKeys[i] = events[i].UserKeys
+	}
+
+	if err := loadReads(ctx, readables, readUserKeys); err != nil {
+		return events, err
+	}
+
 	// We add the just retrieved user objects to their corresponding events by
 	// iterating through all of the events and assigning their users according
 	// to the index which we created above.
@@ -525,6 +648,12 @@ func GetEventsByUser(ctx context.Context, u *User, p *Pagination) ([]*Event, err
 		events[i].HostPartials = MapUsersToUserPartials(eventHosts)
 		events[i].UserReads = MapReadsToUserPartials(events[i], eventUsers)
 
+		unreadCount, err := GetUnreadCount(ctx, events[i], u.Key)
+		if err != nil {
+			return events, err
+		}
+		events[i].UnreadCount = unreadCount
+
 		start += idxs[i]
 		eventPtrs[i] = events[i]
 	}
@@ -566,6 +695,10 @@ func handleGetEvent(ctx context.Context, key *datastore.Key, e Event) (Event, er
 		}
 	}
 
+	if err := loadReadsByParent(ctx, &e); err != nil {
+		return e, err
+	}
+
 	e.UserPartials = MapUsersToUserPartials(userPointers)
 	e.Users = userPointers
 	e.Owner = MapUserToUserPartial(&owner)
@@ -11,6 +11,7 @@ import (
 
 	"github.com/hiconvo/api/db"
 	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/utils/i18n"
 )
 
 func swapKeys(keyList []*datastore.Key, oldKey, newKey *datastore.Key) []*datastore.Key {
@@ -34,23 +35,14 @@ func swapKeys(keyList []*datastore.Key, oldKey, newKey *datastore.Key) []*datast
 	return clean
 }
 
-func swapReadUserKeys(readList []*Read, oldKey, newKey *datastore.Key) []*Read {
-	var clean []*Read
-	seen := map[string]struct{}{}
-	for i := range readList {
-		keyString := readList[i].UserKey.String()
-		if _, isSeen := seen[keyString]; !isSeen {
-			seen[keyString] = struct{}{}
-
-			if readList[i].UserKey.Equal(oldKey) {
-				readList[i].UserKey = newKey
-			}
-
-			clean = append(clean, readList[i])
+func containsKey(keys []*datastore.Key, key *datastore.Key) bool {
+	for i := range keys {
+		if keys[i].Equal(key) {
+			return true
 		}
 	}
 
-	return clean
+	return false
 }
 
 func mergeContacts(a, b []*datastore.Key) []*datastore.Key {
@@ -105,7 +97,6 @@ func reassignMessageUsers(ctx context.Context, tx *datastore.Transaction, old, n
 	userMessageKeys := make([]*datastore.Key, len(userMessages))
 	for i := range userMessages {
 		userMessages[i].UserKey = newUser.Key
-		userMessages[i].Reads = swapReadUserKeys(userMessages[i].Reads, old.Key, newUser.Key)
 		userMessageKeys[i] = userMessages[i].Key
 	}
 
@@ -128,7 +119,6 @@ func reassignThreadUsers(ctx context.Context, tx *datastore.Transaction, old, ne
 	userThreadKeys := make([]*datastore.Key, len(userThreads))
 	for i := range userThreads {
 		userThreads[i].UserKeys = swapKeys(userThreads[i].UserKeys, old.Key, newUser.Key)
-		userThreads[i].Reads = swapReadUserKeys(userThreads[i].Reads, old.Key, newUser.Key)
 
 		if userThreads[i].OwnerKey.Equal(old.Key) {
 			userThreads[i].OwnerKey = newUser.Key
@@ -157,7 +147,6 @@ func reassignEventUsers(ctx context.Context, tx *datastore.Transaction, old, new
 	for i := range userEvents {
 		userEvents[i].UserKeys = swapKeys(userEvents[i].UserKeys, old.Key, newUser.Key)
 		userEvents[i].RSVPKeys = swapKeys(userEvents[i].RSVPKeys, old.Key, newUser.Key)
-		userEvents[i].Reads = swapReadUserKeys(userEvents[i].Reads, old.Key, newUser.Key)
 
 		if userEvents[i].OwnerKey.Equal(old.Key) {
 			userEvents[i].OwnerKey = newUser.Key
@@ -175,6 +164,54 @@ func reassignEventUsers(ctx context.Context, tx *datastore.Transaction, old, new
 	return nil
 }
 
+func reassignReads(ctx context.Context, tx *datastore.Transaction, old, newUser *User) error {
+	var oldReads []*Read
+	q := datastore.NewQuery("Read").Filter("UserKey =", old.Key)
+	oldKeys, err := db.DefaultClient.GetAll(ctx, q, &oldReads)
+	if err != nil {
+		return err
+	}
+
+	// Reads are named after their user, so they have to be moved to new
+	// keys rather than updated in place
+	newKeys := make([]*datastore.Key, len(oldReads))
+	for i := range oldReads {
+		newKeys[i] = newReadKey(oldKeys[i].Parent, newUser.Key)
+	}
+
+	// The new user may have read the same thread or event, in which case
+	// whichever of the two read it later wins
+	newReads := make([]*Read, len(newKeys))
+	err = tx.GetMulti(newKeys, newReads)
+	merr, isMultiErr := err.(datastore.MultiError)
+	if err != nil && !isMultiErr {
+		return err
+	}
+
+	for i := range oldReads {
+		if isMultiErr && merr[i] != nil {
+			if merr[i] != datastore.ErrNoSuchEntity {
+				return merr[i]
+			}
+		} else if newReads[i].Timestamp.After(oldReads[i].Timestamp) {
+			oldReads[i].Timestamp = newReads[i].Timestamp
+		}
+
+		oldReads[i].Key = newKeys[i]
+		oldReads[i].UserKey = newUser.Key
+	}
+
+	if _, err := tx.PutMulti(newKeys, oldReads); err != nil {
+		return err
+	}
+
+	if err := tx.DeleteMulti(oldKeys); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func readStringFromFile(file string) string {
 	op := errors.Opf("models.readStringFromFile(file=%s)", file)
 
@@ -201,3 +238,21 @@ func readStringFromFile(file string) string {
 
 	return string(b)
 }
+
+// readLocalizedStringsFromFile reads every translation of the given content
+// file, keyed by locale. The default locale's copy is at the root of the
+// content directory and translations are in subdirectories named after
+// their locale.
+func readLocalizedStringsFromFile(file string) map[string]string {
+	strs := make(map[string]string)
+
+	for _, locale := range i18n.Locales() {
+		if locale == i18n.Default {
+			strs[locale] = readStringFromFile(file)
+		} else {
+			strs[locale] = readStringFromFile(path.Join(locale, file))
+		}
+	}
+
+	return strs
+}
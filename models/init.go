@@ -2,7 +2,10 @@ package models
 
 import (
 	"context"
+	"strconv"
+	"time"
 
+	"github.com/hiconvo/api/errors"
 	"github.com/hiconvo/api/log"
 	"github.com/hiconvo/api/utils/secrets"
 )
@@ -10,9 +13,50 @@ import (
 var (
 	_supportUser    *User
 	_welcomeMessage string = readStringFromFile("welcome.md")
+
+	// _messageEditWindow is how long after sending a message its author
+	// can edit it.
+	_messageEditWindow time.Duration
+
+	// _blobGracePeriod is how old a file must be before it is deleted for
+	// not being referred to, so that files that were just saved aren't.
+	_blobGracePeriod time.Duration
+
+	// _userStorageQuota and _convoStorageQuota bound how many bytes of
+	// photos and attachments a user can send, and how many can be sent to a
+	// thread or event.
+	_userStorageQuota  int
+	_convoStorageQuota int
 )
 
 func init() {
+	editWindow, err := time.ParseDuration(secrets.Get("MESSAGE_EDIT_WINDOW", "15m"))
+	if err != nil {
+		panic(errors.E(errors.Op("models.init"), err))
+	}
+
+	_messageEditWindow = editWindow
+
+	gracePeriod, err := time.ParseDuration(secrets.Get("BLOB_GRACE_PERIOD", "72h"))
+	if err != nil {
+		panic(errors.E(errors.Op("models.init"), err))
+	}
+
+	_blobGracePeriod = gracePeriod
+
+	userQuota, err := strconv.Atoi(secrets.Get("USER_STORAGE_QUOTA_MB", "1024"))
+	if err != nil {
+		panic(errors.E(errors.Op("models.init"), err))
+	}
+
+	convoQuota, err := strconv.Atoi(secrets.Get("CONVO_STORAGE_QUOTA_MB", "2048"))
+	if err != nil {
+		panic(errors.E(errors.Op("models.init"), err))
+	}
+
+	_userStorageQuota = userQuota << 20
+	_convoStorageQuota = convoQuota << 20
+
 	supportPassword := secrets.Get("SUPPORT_PASSWORD", "support")
 	ctx := context.Background()
 
@@ -8,6 +8,7 @@ import (
 	"github.com/hiconvo/api/log"
 	"github.com/hiconvo/api/mail"
 	"github.com/hiconvo/api/template"
+	"github.com/hiconvo/api/utils/i18n"
 	"github.com/hiconvo/api/utils/magic"
 )
 
@@ -17,16 +18,17 @@ const (
 )
 
 var (
-	_tplStrPasswordReset = readStringFromFile("password-reset.txt")
-	_tplStrVerifyEmail   = readStringFromFile("verify-email.txt")
-	_tplStrMergeAccounts = readStringFromFile("merge-accounts.txt")
+	_tplStrPasswordReset = readLocalizedStringsFromFile("password-reset.txt")
+	_tplStrVerifyEmail   = readLocalizedStringsFromFile("verify-email.txt")
+	_tplStrMergeAccounts = readLocalizedStringsFromFile("merge-accounts.txt")
 )
 
 func sendPasswordResetEmail(u *User, magicLink string) error {
 	plainText, html, err := template.RenderAdminEmail(template.AdminEmail{
-		Body:       _tplStrPasswordReset,
-		ButtonText: "Set password",
+		Body:       _tplStrPasswordReset[i18n.Normalize(u.Locale)],
+		ButtonText: i18n.T(u.Locale, "admin.setPassword"),
 		MagicLink:  magicLink,
+		Locale:     u.Locale,
 	})
 	if err != nil {
 		return err
@@ -37,7 +39,7 @@ func sendPasswordResetEmail(u *User, magicLink string) error {
 		FromEmail:   _fromEmail,
 		ToName:      u.FullName,
 		ToEmail:     u.Email,
-		Subject:     "[convo] Set Password",
+		Subject:     i18n.T(u.Locale, "admin.setPasswordTitle"),
 		TextContent: plainText,
 		HTMLContent: html,
 	}
@@ -47,9 +49,10 @@ func sendPasswordResetEmail(u *User, magicLink string) error {
 
 func sendVerifyEmail(u *User, emailAddress, magicLink string) error {
 	plainText, html, err := template.RenderAdminEmail(template.AdminEmail{
-		Body:       _tplStrVerifyEmail,
-		ButtonText: "Verify",
+		Body:       _tplStrVerifyEmail[i18n.Normalize(u.Locale)],
+		ButtonText: i18n.T(u.Locale, "admin.verify"),
 		MagicLink:  magicLink,
+		Locale:     u.Locale,
 	})
 	if err != nil {
 		return err
@@ -60,7 +63,7 @@ func sendVerifyEmail(u *User, emailAddress, magicLink string) error {
 		FromEmail:   _fromEmail,
 		ToName:      u.FullName,
 		ToEmail:     emailAddress,
-		Subject:     "[convo] Verify Email",
+		Subject:     i18n.T(u.Locale, "admin.verifyTitle"),
 		TextContent: plainText,
 		HTMLContent: html,
 	}
@@ -70,10 +73,11 @@ func sendVerifyEmail(u *User, emailAddress, magicLink string) error {
 
 func sendMergeAccountsEmail(u *User, emailToMerge, magicLink string) error {
 	plainText, html, err := template.RenderAdminEmail(template.AdminEmail{
-		Body:       _tplStrMergeAccounts,
-		ButtonText: "Verify",
+		Body:       _tplStrMergeAccounts[i18n.Normalize(u.Locale)],
+		ButtonText: i18n.T(u.Locale, "admin.verify"),
 		MagicLink:  magicLink,
 		Fargs:      []interface{}{emailToMerge, u.Email},
+		Locale:     u.Locale,
 	})
 	if err != nil {
 		return err
@@ -84,7 +88,7 @@ func sendMergeAccountsEmail(u *User, emailToMerge, magicLink string) error {
 		FromEmail:   _fromEmail,
 		ToName:      u.FullName,
 		ToEmail:     u.Email,
-		Subject:     "[convo] Verify Email",
+		Subject:     i18n.T(u.Locale, "admin.verifyTitle"),
 		TextContent: plainText,
 		HTMLContent: html,
 	}
@@ -92,28 +96,34 @@ func sendMergeAccountsEmail(u *User, emailToMerge, magicLink string) error {
 	return mail.Send(email)
 }
 
+// getReplyToName returns the first name of the author of the message that
+// reply quotes, or a stand-in in the given locale if they have been deleted.
+func getReplyToName(reply *MessageReply, locale string) string {
+	if reply.User == nil {
+		return i18n.T(locale, "message.deletedUser")
+	}
+
+	return reply.User.FirstName
+}
+
 func sendThread(thread *Thread, messages []*Message) error {
 	if len(messages) == 0 {
 		return errors.E(errors.Op("models.sendThread"), errors.Str("no messages to send"))
 	}
 
-	// From is the most recent message sender: messages[0].User.
-	sender, err := MapUserPartialToUser(messages[0].User, thread.Users)
+	// From is the most recent message sender. Messages are sorted oldest
+	// first, so that is the last one.
+	sender, err := MapUserPartialToUser(messages[len(messages)-1].User, thread.Users)
 	if err != nil {
 		return err
 	}
 
-	// Loop through all participants and generate emails.
-	//
-	emailMessages := make([]mail.EmailMessage, len(thread.Users))
+	// Loop through the participants who want this message and generate emails.
+	recipients := thread.GetEmailRecipients(messages[len(messages)-1])
+	emailMessages := make([]mail.EmailMessage, len(recipients))
 	// Get the last five messages to be included in the email.
 	lastFive := getLastFive(messages)
-	for i, curUser := range thread.Users {
-		// Don't send an email to the sender.
-		if curUser.Key.Equal(sender.Key) {
-			continue
-		}
-
+	for i, curUser := range recipients {
 		// Generate messages
 		tplMessages := make([]template.Message, len(lastFive))
 		for j, m := range lastFive {
@@ -125,62 +135,125 @@ func sendThread(thread *Thread, messages []*Message) error {
 				FromID:   m.User.ID,
 				ToID:     curUser.ID,
 			}
+
+			if m.ReplyTo != nil {
+				tplMessages[j].ReplyToName = getReplyToName(m.ReplyTo, curUser.Locale)
+				tplMessages[j].ReplyToBody = m.ReplyTo.Body
+			}
 		}
 
+		unsubscribeLink := magic.NewUnsubscribeLink(curUser.Key, EmailCategoryThreads)
+
 		plainText, html, err := template.RenderThread(template.Thread{
-			Subject:   thread.Subject,
-			FromName:  sender.FullName,
-			Messages:  tplMessages,
-			MagicLink: magic.NewLink(curUser.Key, curUser.Token, "magic"),
+			Subject:         thread.Subject,
+			FromName:        sender.FullName,
+			Messages:        tplMessages,
+			MagicLink:       magic.NewLink(curUser.Key, curUser.Token, "magic"),
+			UnsubscribeLink: unsubscribeLink,
+			Locale:          curUser.Locale,
 		})
 		if err != nil {
 			return err
 		}
 
 		emailMessages[i] = mail.EmailMessage{
-			FromName:    sender.FullName,
-			FromEmail:   thread.GetEmail(),
-			ToName:      curUser.FullName,
-			ToEmail:     curUser.Email,
-			Subject:     thread.Subject,
-			TextContent: plainText,
-			HTMLContent: html,
+			FromName:       sender.FullName,
+			FromEmail:      thread.GetReplyEmail(curUser),
+			ToName:         curUser.FullName,
+			ToEmail:        curUser.Email,
+			Subject:        thread.Subject,
+			TextContent:    plainText,
+			HTMLContent:    html,
+			UnsubscribeURL: unsubscribeLink,
 		}
 	}
 
-	for i := range emailMessages {
-		if emailMessages[i].FromEmail == "" {
-			continue
+	return sendMulti(errors.Op("models.sendThread"), emailMessages)
+}
+
+// sendEventMentions emails the latest of the messages of the event to the
+// members it mentions. It is laid out like a thread email.
+func sendEventMentions(event *Event, messages []*Message) error {
+	if len(messages) == 0 {
+		return errors.E(errors.Op("models.sendEventMentions"), errors.Str("no messages to send"))
+	}
+
+	latest := messages[len(messages)-1]
+	sender, err := MapUserPartialToUser(latest.User, event.Users)
+	if err != nil {
+		return err
+	}
+
+	recipients := event.GetMentionRecipients(latest)
+	emailMessages := make([]mail.EmailMessage, len(recipients))
+	lastFive := getLastFive(messages)
+	for i, curUser := range recipients {
+		tplMessages := make([]template.Message, len(lastFive))
+		for j, m := range lastFive {
+			tplMessages[j] = template.Message{
+				Body:     m.Body,
+				Name:     m.User.FirstName,
+				HasPhoto: m.HasPhoto(),
+				HasLink:  m.HasLink(),
+				FromID:   m.User.ID,
+				ToID:     curUser.ID,
+			}
+
+			if m.ReplyTo != nil {
+				tplMessages[j].ReplyToName = getReplyToName(m.ReplyTo, curUser.Locale)
+				tplMessages[j].ReplyToBody = m.ReplyTo.Body
+			}
+		}
+
+		unsubscribeLink := magic.NewUnsubscribeLink(curUser.Key, EmailCategoryEvents)
+
+		plainText, html, err := template.RenderThread(template.Thread{
+			Subject:         event.Name,
+			FromName:        sender.FullName,
+			Messages:        tplMessages,
+			MagicLink:       magic.NewLink(curUser.Key, curUser.Token, "magic"),
+			UnsubscribeLink: unsubscribeLink,
+			Locale:          curUser.Locale,
+		})
+		if err != nil {
+			return err
 		}
 
-		if err := mail.Send(emailMessages[i]); err != nil {
-			log.Alarm(errors.Errorf("models.sendThread: %v", err))
+		emailMessages[i] = mail.EmailMessage{
+			FromName:       sender.FullName,
+			FromEmail:      event.GetEmail(),
+			ToName:         curUser.FullName,
+			ToEmail:        curUser.Email,
+			Subject:        event.Name,
+			TextContent:    plainText,
+			HTMLContent:    html,
+			UnsubscribeURL: unsubscribeLink,
 		}
 	}
 
-	return nil
+	return sendMulti(errors.Op("models.sendEventMentions"), emailMessages)
 }
 
 func sendEvent(event *Event, isUpdate bool) error {
-	var fmtStr string
+	subjectKey := "event.subject"
 	if isUpdate {
-		fmtStr = "Updated invitation to %s"
-	} else {
-		fmtStr = "Invitation to %s"
+		subjectKey = "event.updatedSubject"
 	}
 
 	// Loop through all participants and generate emails
-	emailMessages := make([]mail.EmailMessage, len(event.Users))
-	for i, curUser := range event.Users {
-		// Don't send invitations to the host
-		if event.OwnerIs(curUser) {
+	emailMessages := make([]mail.EmailMessage, 0, len(event.Users))
+	for _, curUser := range event.Users {
+		// Don't send invitations to the host or to those who opted out
+		if event.OwnerIs(curUser) || !curUser.WantsEventEmails() {
 			continue
 		}
 
+		unsubscribeLink := magic.NewUnsubscribeLink(curUser.Key, EmailCategoryEvents)
+
 		plainText, html, err := template.RenderEvent(template.Event{
 			Name:        event.Name,
 			Address:     event.Address,
-			Time:        event.GetFormatedTime(),
+			Time:        event.GetFormatedTime(curUser.Locale),
 			Description: event.Description,
 			FromName:    event.Owner.FullName,
 			MagicLink: magic.NewLink(
@@ -188,42 +261,41 @@ func sendEvent(event *Event, isUpdate bool) error {
 				strconv.FormatBool(!event.IsInFuture()),
 				fmt.Sprintf("rsvp/%s",
 					event.Key.Encode())),
-			ButtonText: "RSVP",
+			ButtonText:      i18n.T(curUser.Locale, "event.rsvp"),
+			UnsubscribeLink: unsubscribeLink,
+			Locale:          curUser.Locale,
 		})
 		if err != nil {
 			return err
 		}
 
-		emailMessages[i] = mail.EmailMessage{
-			FromName:      event.Owner.FullName,
-			FromEmail:     event.GetEmail(),
-			ToName:        curUser.FullName,
-			ToEmail:       curUser.Email,
-			Subject:       fmt.Sprintf(fmtStr, event.Name),
-			TextContent:   plainText,
-			HTMLContent:   html,
-			ICSAttachment: event.GetICS(),
-		}
+		emailMessages = append(emailMessages, mail.EmailMessage{
+			FromName:       event.Owner.FullName,
+			FromEmail:      event.GetEmail(),
+			ToName:         curUser.FullName,
+			ToEmail:        curUser.Email,
+			Subject:        i18n.T(curUser.Locale, subjectKey, event.Name),
+			TextContent:    plainText,
+			HTMLContent:    html,
+			ICSAttachment:  event.GetICS(),
+			UnsubscribeURL: unsubscribeLink,
+		})
 	}
 
-	for i := range emailMessages {
-		if emailMessages[i].FromEmail == "" {
-			continue
-		}
+	return sendMulti(errors.Op("models.sendEvent"), emailMessages)
+}
 
-		if err := mail.Send(emailMessages[i]); err != nil {
-			log.Alarm(errors.Errorf("models.sendEvent: %v", err))
-		}
+func sendEventInvitation(event *Event, user *User) error {
+	if !user.WantsEventEmails() {
+		return nil
 	}
 
-	return nil
-}
+	unsubscribeLink := magic.NewUnsubscribeLink(user.Key, EmailCategoryEvents)
 
-func sendEventInvitation(event *Event, user *User) error {
 	plainText, html, err := template.RenderEvent(template.Event{
 		Name:        event.Name,
 		Address:     event.Address,
-		Time:        event.GetFormatedTime(),
+		Time:        event.GetFormatedTime(user.Locale),
 		Description: event.Description,
 		FromName:    event.Owner.FullName,
 		MagicLink: magic.NewLink(
@@ -231,21 +303,24 @@ func sendEventInvitation(event *Event, user *User) error {
 			strconv.FormatBool(!event.IsInFuture()),
 			fmt.Sprintf("rsvp/%s",
 				event.Key.Encode())),
-		ButtonText: "RSVP",
+		ButtonText:      i18n.T(user.Locale, "event.rsvp"),
+		UnsubscribeLink: unsubscribeLink,
+		Locale:          user.Locale,
 	})
 	if err != nil {
 		return err
 	}
 
 	email := mail.EmailMessage{
-		FromName:      event.Owner.FullName,
-		FromEmail:     event.GetEmail(),
-		ToName:        user.FullName,
-		ToEmail:       user.Email,
-		Subject:       fmt.Sprintf("Invitation to %s", event.Name),
-		TextContent:   plainText,
-		HTMLContent:   html,
-		ICSAttachment: event.GetICS(),
+		FromName:       event.Owner.FullName,
+		FromEmail:      event.GetEmail(),
+		ToName:         user.FullName,
+		ToEmail:        user.Email,
+		Subject:        i18n.T(user.Locale, "event.subject", event.Name),
+		TextContent:    plainText,
+		HTMLContent:    html,
+		ICSAttachment:  event.GetICS(),
+		UnsubscribeURL: unsubscribeLink,
 	}
 
 	return mail.Send(email)
@@ -253,41 +328,40 @@ func sendEventInvitation(event *Event, user *User) error {
 
 func sendCancellation(event *Event, message string) error {
 	// Loop through all participants and generate emails
-	emailMessages := make([]mail.EmailMessage, len(event.Users))
-	for i, curUser := range event.Users {
+	emailMessages := make([]mail.EmailMessage, 0, len(event.Users))
+	for _, curUser := range event.Users {
+		if !curUser.WantsEventEmails() {
+			continue
+		}
+
+		unsubscribeLink := magic.NewUnsubscribeLink(curUser.Key, EmailCategoryEvents)
+
 		plainText, html, err := template.RenderCancellation(template.Event{
-			Name:     event.Name,
-			Address:  event.Address,
-			Time:     event.GetFormatedTime(),
-			FromName: event.Owner.FullName,
-			Message:  message,
+			Name:            event.Name,
+			Address:         event.Address,
+			Time:            event.GetFormatedTime(curUser.Locale),
+			FromName:        event.Owner.FullName,
+			Message:         message,
+			UnsubscribeLink: unsubscribeLink,
+			Locale:          curUser.Locale,
 		})
 		if err != nil {
 			return err
 		}
 
-		emailMessages[i] = mail.EmailMessage{
-			FromName:    event.Owner.FullName,
-			FromEmail:   event.GetEmail(),
-			ToName:      curUser.FullName,
-			ToEmail:     curUser.Email,
-			Subject:     fmt.Sprintf("Cancelled: %s", event.Name),
-			TextContent: plainText,
-			HTMLContent: html,
-		}
-	}
-
-	for i := range emailMessages {
-		if emailMessages[i].FromEmail == "" {
-			continue
-		}
-
-		if err := mail.Send(emailMessages[i]); err != nil {
-			log.Alarm(errors.Errorf("models.sendCancellation: %v", err))
-		}
+		emailMessages = append(emailMessages, mail.EmailMessage{
+			FromName:       event.Owner.FullName,
+			FromEmail:      event.GetEmail(),
+			ToName:         curUser.FullName,
+			ToEmail:        curUser.Email,
+			Subject:        i18n.T(curUser.Locale, "event.cancelledSubject", event.Name),
+			TextContent:    plainText,
+			HTMLContent:    html,
+			UnsubscribeURL: unsubscribeLink,
+		})
 	}
 
-	return nil
+	return sendMulti(errors.Op("models.sendCancellation"), emailMessages)
 }
 
 func sendDigest(digestList []DigestItem, upcomingEvents []*Event, user *User) error {
@@ -318,36 +392,61 @@ func sendDigest(digestList []DigestItem, upcomingEvents []*Event, user *User) er
 		templateEvents[i] = template.Event{
 			Name:    upcomingEvents[i].Name,
 			Address: upcomingEvents[i].Address,
-			Time:    upcomingEvents[i].GetFormatedTime(),
+			Time:    upcomingEvents[i].GetFormatedTime(user.Locale),
 		}
 	}
 
+	unsubscribeLink := magic.NewUnsubscribeLink(user.Key, EmailCategoryDigest)
+
 	// Render all the stuff
 	plainText, html, err := template.RenderDigest(template.Digest{
-		Items:     items,
-		Events:    templateEvents,
-		MagicLink: magic.NewLink(user.Key, user.Token, "magic"),
+		Items:           items,
+		Events:          templateEvents,
+		MagicLink:       magic.NewLink(user.Key, user.Token, "magic"),
+		UnsubscribeLink: unsubscribeLink,
+		Locale:          user.Locale,
 	})
 	if err != nil {
 		return err
 	}
 
 	email := mail.EmailMessage{
-		FromName:    _fromName,
-		FromEmail:   _fromEmail,
-		ToName:      user.FullName,
-		ToEmail:     user.Email,
-		Subject:     "[convo] Digest",
-		TextContent: plainText,
-		HTMLContent: html,
+		FromName:       _fromName,
+		FromEmail:      _fromEmail,
+		ToName:         user.FullName,
+		ToEmail:        user.Email,
+		Subject:        i18n.T(user.Locale, "digest.subject"),
+		TextContent:    plainText,
+		HTMLContent:    html,
+		UnsubscribeURL: unsubscribeLink,
 	}
 
 	return mail.Send(email)
 }
 
+// sendMulti sends emails to many recipients at once. Failures for some
+// recipients are alarmed on rather than returned since retrying the whole
+// batch would email everyone else again. If nothing could be sent at all,
+// the failure is returned instead.
+func sendMulti(op errors.Op, emails []mail.EmailMessage) error {
+	summary := mail.SendMulti(emails)
+
+	if summary.Failed > 0 && summary.Sent == 0 {
+		return errors.E(op, errors.Str(summary.String()))
+	}
+
+	if summary.Failed > 0 {
+		log.Alarm(errors.E(op, errors.Str(summary.String())))
+	} else {
+		log.Printf("%s: %s", op, summary)
+	}
+
+	return nil
+}
+
 func getLastFive(messages []*Message) []*Message {
 	if len(messages) > 5 {
-		return messages[:5]
+		return messages[len(messages)-5:]
 	}
 
 	return messages
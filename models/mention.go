@@ -0,0 +1,56 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"cloud.google.com/go/datastore"
+)
+
+// findMentions returns the keys of the users who are mentioned in body. A
+// user is mentioned by an @ followed by their full name or first name, in
+// any case. Authors can't mention themselves.
+func findMentions(body string, author *User, users []*User) []*datastore.Key {
+	lowerBody := strings.ToLower(body)
+	if !strings.Contains(lowerBody, "@") {
+		return nil
+	}
+
+	var keys []*datastore.Key
+	for _, u := range users {
+		if u.Key.Equal(author.Key) || containsKey(keys, u.Key) {
+			continue
+		}
+
+		partial := MapUserToUserPartial(u)
+		for _, name := range []string{partial.FullName, partial.FirstName} {
+			if name != "" && hasMention(lowerBody, strings.ToLower(name)) {
+				keys = append(keys, u.Key)
+				break
+			}
+		}
+	}
+
+	return keys
+}
+
+// hasMention returns true if body contains @name as a whole word, so that
+// "@Ada" mentions Ada but not Adam.
+func hasMention(body, name string) bool {
+	mention := "@" + name
+	for start := 0; ; {
+		i := strings.Index(body[start:], mention)
+		if i < 0 {
+			return false
+		}
+
+		end := start + i + len(mention)
+		next, _ := utf8.DecodeRuneInString(body[end:])
+		if end == len(body) || !(unicode.IsLetter(next) || unicode.IsDigit(next)) {
+			return true
+		}
+
+		start = end
+	}
+}
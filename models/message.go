@@ -3,11 +3,14 @@ package models
 import (
 	"context"
 	"fmt"
-	"sort"
+	"net/http"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
 
 	"github.com/hiconvo/api/db"
 	"github.com/hiconvo/api/errors"
@@ -17,21 +20,79 @@ import (
 )
 
 type Message struct {
-	Key       *datastore.Key `json:"-"        datastore:"__key__"`
-	ID        string         `json:"id"       datastore:"-"`
-	UserKey   *datastore.Key `json:"-"`
-	User      *UserPartial   `json:"user"     datastore:"-"`
-	ParentKey *datastore.Key `json:"-"`
-	ParentID  string         `json:"parentId" datastore:"-"`
-	Body      string         `json:"body"     datastore:",noindex"`
-	Timestamp time.Time      `json:"timestamp"`
-	Reads     []*Read        `json:"-"        datastore:",noindex"`
-	PhotoKeys []string       `json:"-"`
-	Photos    []string       `json:"photos"   datastore:"-"`
-	Link      *og.LinkData   `json:"link"     datastore:",noindex"`
-}
-
-func NewThreadMessage(u *User, t *Thread, body, photoKey string, link og.LinkData) (Message, error) {
+	Key            *datastore.Key       `json:"-"        datastore:"__key__"`
+	ID             string               `json:"id"       datastore:"-"`
+	UserKey        *datastore.Key       `json:"-"`
+	User           *UserPartial         `json:"user"     datastore:"-"`
+	ParentKey      *datastore.Key       `json:"-"`
+	ParentID       string               `json:"parentId" datastore:"-"`
+	Body           string               `json:"body"     datastore:",noindex"`
+	Timestamp      time.Time            `json:"timestamp"`
+	PhotoKeys      []string             `json:"-"`
+	Photos         []string             `json:"photos"   datastore:"-"`
+	PhotoSizes     []*storage.PhotoURLs `json:"photoSizes" datastore:"-"`
+	PhotoBytes     []int                `json:"-"        datastore:",noindex"`
+	Attachments    []*Attachment        `json:"attachments" datastore:",noindex"`
+	Link           *og.LinkData         `json:"link"     datastore:",noindex"`
+	ReadBy         []*UserPartial       `json:"readBy"   datastore:"-"`
+	Edits          []*MessageEdit       `json:"edits"    datastore:",noindex"`
+	Reactions      []*Reaction          `json:"-"        datastore:",noindex"`
+	ReactionCounts []*ReactionCount     `json:"reactions" datastore:"-"`
+	ReplyTo        *MessageReply        `json:"replyTo"  datastore:",noindex"`
+	MentionKeys    []*datastore.Key     `json:"-"        datastore:",noindex"`
+	Mentions       []string             `json:"mentions" datastore:"-"`
+
+	hasLegacyReads bool
+}
+
+// MessageEdit is a version of a message from before it was edited.
+// Timestamp is when it was replaced.
+type MessageEdit struct {
+	Body      string       `json:"body"`
+	Link      *og.LinkData `json:"link"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// Reaction is an emoji that a user reacted to a message with.
+type Reaction struct {
+	Emoji   string
+	UserKey *datastore.Key
+}
+
+// ReactionCount is how many users reacted to a message with an emoji, and
+// who they are.
+type ReactionCount struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"userIds"`
+}
+
+// MessageReply is what a reply quotes of the message it replies to. It is
+// copied when the reply is sent and doesn't follow later edits.
+type MessageReply struct {
+	ID        string       `json:"id"`
+	User      *UserPartial `json:"user"`
+	Body      string       `json:"body"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// _replyQuoteLength bounds, in runes, how much of the message replied to
+// is quoted.
+const _replyQuoteLength = 200
+
+// _maxEmojiLength bounds the length of a reaction in runes. Emoji made of
+// several code points, like flags and families, take up to about seven.
+const _maxEmojiLength = 10
+
+func NewThreadMessage(
+	u *User,
+	t *Thread,
+	body string,
+	photoKeys []string,
+	attachments []*Attachment,
+	link og.LinkData,
+	replyTo *Message,
+) (Message, error) {
 	ts := time.Now()
 
 	linkPtr := &link
@@ -48,26 +109,32 @@ func NewThreadMessage(u *User, t *Thread, body, photoKey string, link og.LinkDat
 		Body:      removeLink(body, linkPtr),
 		Timestamp: ts,
 		Link:      linkPtr,
+		ReplyTo:   newMessageReply(replyTo),
 	}
 
-	if photoKey != "" {
-		message.PhotoKeys = []string{photoKey}
-		message.Photos = []string{storage.DefaultClient.GetPhotoURLFromKey(photoKey)}
-	}
+	message.setMentions(findMentions(body, u, t.Users))
+
+	message.setPhotos(photoKeys)
+	message.Attachments = attachments
 
 	if t.Preview == nil {
 		t.Preview = &message
 	}
 
 	t.IncRespCount()
-
-	ClearReads(t)
-	MarkAsRead(t, u.Key)
+	t.UpdatedAt = ts
 
 	return message, nil
 }
 
-func NewEventMessage(u *User, e *Event, body, photoKey string) (Message, error) {
+func NewEventMessage(
+	u *User,
+	e *Event,
+	body string,
+	photoKeys []string,
+	attachments []*Attachment,
+	replyTo *Message,
+) (Message, error) {
 	ts := time.Now()
 
 	message := Message{
@@ -78,15 +145,15 @@ func NewEventMessage(u *User, e *Event, body, photoKey string) (Message, error)
 		ParentID:  e.ID,
 		Body:      body,
 		Timestamp: ts,
+		ReplyTo:   newMessageReply(replyTo),
 	}
 
-	if photoKey != "" {
-		message.PhotoKeys = []string{photoKey}
-		message.Photos = []string{storage.DefaultClient.GetPhotoURLFromKey(photoKey)}
-	}
+	message.setMentions(findMentions(body, u, e.Users))
 
-	ClearReads(e)
-	MarkAsRead(e, u.Key)
+	message.setPhotos(photoKeys)
+	message.Attachments = attachments
+
+	e.UpdatedAt = ts
 
 	return message, nil
 }
@@ -111,7 +178,7 @@ func (m *Message) Load(ps []datastore.Property) error {
 
 	if err := datastore.LoadStruct(m, ps); err != nil {
 		if mismatch, ok := err.(*datastore.ErrFieldMismatch); ok {
-			if mismatch.FieldName != "ThreadKey" {
+			if mismatch.FieldName != "ThreadKey" && mismatch.FieldName != "Reads" {
 				return errors.E(op, err)
 			}
 		} else {
@@ -129,19 +196,155 @@ func (m *Message) Load(ps []datastore.Property) error {
 			m.ParentID = k.Encode()
 		}
 
-		// Convert photoKeys into full URLs
-		if p.Name == "PhotoKeys" {
-			photoKeys, ok := p.Value.([]interface{})
-			if ok {
-				photos := make([]string, len(photoKeys))
-				for i := range photoKeys {
-					photoKey, ok := photoKeys[i].(string)
-					if ok {
-						photos[i] = storage.DefaultClient.GetPhotoURLFromKey(photoKey)
-					}
-				}
+		if p.Name == "Reads" {
+			m.hasLegacyReads = true
+		}
+	}
+
+	m.ReactionCounts = countReactions(m.Reactions)
+	m.setMentions(m.MentionKeys)
+
+	return nil
+}
+
+// Edit replaces the body and link of the message, keeping the previous
+// version in Edits. The mentions are found again among users, the members
+// of the message's thread or event, and those who weren't mentioned before
+// are returned. It fails once the edit window has passed. Checking that
+// the message is being edited by its author is up to the caller.
+func (m *Message) Edit(body string, link og.LinkData, users []*User) ([]*datastore.Key, error) {
+	if time.Since(m.Timestamp) > _messageEditWindow {
+		return nil, errors.E(errors.Op("message.Edit"),
+			errors.Str("EditWindowPassed"),
+			map[string]string{"message": "This message can no longer be edited"},
+			http.StatusBadRequest)
+	}
+
+	linkPtr := &link
+	if link.URL == "" {
+		linkPtr = nil
+	}
+
+	m.Edits = append(m.Edits, &MessageEdit{
+		Body:      m.Body,
+		Link:      m.Link,
+		Timestamp: time.Now(),
+	})
+
+	m.Body = removeLink(body, linkPtr)
+	m.Link = linkPtr
+
+	var newMentions []*datastore.Key
+	mentions := findMentions(body, &User{Key: m.UserKey}, users)
+	for _, k := range mentions {
+		if !containsKey(m.MentionKeys, k) {
+			newMentions = append(newMentions, k)
+		}
+	}
+	m.setMentions(mentions)
+
+	return newMentions, nil
+}
+
+// AddReaction adds a reaction with the given emoji by the user to the
+// message. Reacting twice with the same emoji has no effect. Reactions are
+// not news, so unlike new messages they don't change what is read.
+func (m *Message) AddReaction(u *User, emoji string) error {
+	emoji = strings.TrimSpace(emoji)
+	if !isEmoji(emoji) {
+		return errors.E(errors.Op("message.AddReaction"),
+			errors.Str("InvalidEmoji"),
+			map[string]string{"emoji": "Invalid emoji"},
+			http.StatusBadRequest)
+	}
+
+	if m.hasReaction(u, emoji) {
+		return nil
+	}
+
+	m.Reactions = append(m.Reactions, &Reaction{Emoji: emoji, UserKey: u.Key})
+	m.ReactionCounts = countReactions(m.Reactions)
+
+	return nil
+}
+
+// RemoveReaction removes the reaction with the given emoji by the user from
+// the message, if there is one.
+func (m *Message) RemoveReaction(u *User, emoji string) {
+	emoji = strings.TrimSpace(emoji)
+	for i := range m.Reactions {
+		if m.Reactions[i].Emoji == emoji && m.Reactions[i].UserKey.Equal(u.Key) {
+			m.Reactions = append(m.Reactions[:i], m.Reactions[i+1:]...)
+			break
+		}
+	}
+
+	m.ReactionCounts = countReactions(m.Reactions)
+}
+
+func (m *Message) hasReaction(u *User, emoji string) bool {
+	for i := range m.Reactions {
+		if m.Reactions[i].Emoji == emoji && m.Reactions[i].UserKey.Equal(u.Key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Message) setPhotos(photoKeys []string) {
+	if len(photoKeys) == 0 {
+		return
+	}
+
+	m.PhotoKeys = photoKeys
+}
+
+// SignURLs sets the URLs of the photos and attachments of the message. See
+// SignMessageURLs.
+func (m *Message) SignURLs(ctx context.Context) error {
+	return SignMessageURLs(ctx, []*Message{m})
+}
+
+// SignMessageURLs sets the URLs of the photos and attachments of the given
+// messages to signed URLs that expire after a while. The files are private,
+// so this must only be done once it is known that the user can see the
+// thread or event that the messages are in.
+func SignMessageURLs(ctx context.Context, messages []*Message) error {
+	op := errors.Op("models.SignMessageURLs")
+
+	var photoKeys, fileKeys []string
+	for _, m := range messages {
+		photoKeys = append(photoKeys, m.PhotoKeys...)
+		for _, a := range m.Attachments {
+			fileKeys = append(fileKeys, a.Key)
+		}
+	}
+
+	if len(photoKeys) > 0 {
+		photos, err := storage.DefaultClient.GetSignedPhotoURLs(ctx, photoKeys)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		for _, m := range messages {
+			m.PhotoSizes, photos = photos[:len(m.PhotoKeys)], photos[len(m.PhotoKeys):]
+			m.Photos = make([]string, len(m.PhotoSizes))
+			for i := range m.PhotoSizes {
+				m.Photos[i] = m.PhotoSizes[i].Full
+			}
+		}
+	}
+
+	if len(fileKeys) > 0 {
+		urls, err := storage.DefaultClient.GetSignedFileURLs(ctx, fileKeys)
+		if err != nil {
+			return errors.E(op, err)
+		}
 
-				m.Photos = photos
+		for _, m := range messages {
+			for _, a := range m.Attachments {
+				a.URL, urls = urls[0], urls[1:]
 			}
 		}
 	}
@@ -149,12 +352,17 @@ func (m *Message) Load(ps []datastore.Property) error {
 	return nil
 }
 
-func (m *Message) GetReads() []*Read {
-	return m.Reads
+// IsMentioned returns true if u is mentioned in the message.
+func (m *Message) IsMentioned(u *User) bool {
+	return containsKey(m.MentionKeys, u.Key)
 }
 
-func (m *Message) SetReads(newReads []*Read) {
-	m.Reads = newReads
+func (m *Message) setMentions(keys []*datastore.Key) {
+	m.MentionKeys = keys
+	m.Mentions = make([]string, len(keys))
+	for i := range keys {
+		m.Mentions[i] = keys[i].Encode()
+	}
 }
 
 func (m *Message) HasPhoto() bool {
@@ -189,18 +397,20 @@ func (m *Message) DeletePhoto(ctx context.Context, key string) error {
 			if m.PhotoKeys[i] == key {
 				m.PhotoKeys[i] = m.PhotoKeys[len(m.PhotoKeys)-1]
 				m.PhotoKeys = m.PhotoKeys[:len(m.PhotoKeys)-1]
-				break
-			}
-		}
 
-		for i := range m.Photos {
-			if strings.HasSuffix(m.Photos[i], key) {
-				m.Photos[i] = m.Photos[len(m.Photos)-1]
-				m.Photos = m.Photos[:len(m.Photos)-1]
+				// Messages from before sizes were kept have none
+				if i < len(m.PhotoBytes) {
+					m.PhotoBytes[i] = m.PhotoBytes[len(m.PhotoBytes)-1]
+					m.PhotoBytes = m.PhotoBytes[:len(m.PhotoBytes)-1]
+				}
 				break
 			}
 		}
 
+		// The URLs are signed again from the keys that are left
+		m.Photos = nil
+		m.PhotoSizes = nil
+
 		if err := storage.DefaultClient.DeletePhoto(ctx, key); err != nil {
 			log.Alarm(errors.E(errors.Op("models.DeletePhoto"), err))
 		}
@@ -221,6 +431,10 @@ func (m *Message) Commit(ctx context.Context) error {
 	return nil
 }
 
+func (m *Message) CommitWithTransaction(tx db.Transaction) (*datastore.PendingKey, error) {
+	return tx.Put(m.Key, m)
+}
+
 func (m *Message) Delete(ctx context.Context) error {
 	if err := db.DefaultClient.Delete(ctx, m.Key); err != nil {
 		return err
@@ -228,20 +442,40 @@ func (m *Message) Delete(ctx context.Context) error {
 	return nil
 }
 
-func GetMessagesByThread(ctx context.Context, t *Thread) ([]*Message, error) {
-	return GetMessagesByKey(ctx, t.Key)
+func GetMessagesByThread(ctx context.Context, t *Thread, p *Pagination) ([]*Message, error) {
+	return GetMessagesByKey(ctx, t.Key, p)
 }
 
-func GetMessagesByEvent(ctx context.Context, e *Event) ([]*Message, error) {
-	return GetMessagesByKey(ctx, e.Key)
+func GetMessagesByEvent(ctx context.Context, e *Event, p *Pagination) ([]*Message, error) {
+	return GetMessagesByKey(ctx, e.Key, p)
 }
 
-func GetMessagesByKey(ctx context.Context, k *datastore.Key) ([]*Message, error) {
+// GetMessagesByKey returns a page of the messages of the thread or event
+// with the given key, oldest first.
+func GetMessagesByKey(ctx context.Context, k *datastore.Key, p *Pagination) ([]*Message, error) {
 	var messages []*Message
 
-	q := datastore.NewQuery("Message").Filter("ParentKey =", k)
+	q, err := p.apply(datastore.NewQuery("Message").
+		Filter("ParentKey =", k).
+		Order("Timestamp"))
+	if err != nil {
+		return messages, err
+	}
 
-	if _, err := db.DefaultClient.GetAll(ctx, q, &messages); err != nil {
+	it := db.DefaultClient.Run(ctx, q)
+	for {
+		var m Message
+		_, err := it.Next(&m)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return messages, err
+		}
+
+		messages = append(messages, &m)
+	}
+
+	if err := p.setNext(it, len(messages)); err != nil {
 		return messages, err
 	}
 
@@ -258,11 +492,6 @@ func GetMessagesByKey(ctx context.Context, k *datastore.Key) ([]*Message, error)
 		messages[i].User = MapUserToUserPartial(users[i])
 	}
 
-	// TODO: Get Query#Order to work above.
-	sort.Slice(messages, func(i, j int) bool {
-		return messages[i].Timestamp.Before(messages[j].Timestamp)
-	})
-
 	return messages, nil
 }
 
@@ -293,6 +522,63 @@ func GetMessageByID(ctx context.Context, id string) (Message, error) {
 	return message, nil
 }
 
+// GetReplyTarget returns the message with the given ID for a new message of
+// the thread or event with the given key to reply to. The message must
+// belong to the same thread or event. Its user is set unless they have
+// since been deleted.
+func GetReplyTarget(ctx context.Context, parentKey *datastore.Key, id string) (*Message, error) {
+	op := errors.Op("models.GetReplyTarget")
+	invalid := map[string]string{"replyTo": "Invalid message"}
+
+	m, err := GetMessageByID(ctx, id)
+	if err != nil {
+		return nil, errors.E(op, err, invalid, http.StatusBadRequest)
+	}
+
+	if !parentKey.Equal(m.ParentKey) {
+		return nil, errors.E(op, errors.Str("NotSibling"), invalid, http.StatusBadRequest)
+	}
+
+	// The author is read with the request's transaction directly, if there
+	// is one, since the client rolls it back if they aren't found
+	var u User
+	if tx, ok := db.TransactionFromContext(ctx); ok {
+		err = tx.Get(m.UserKey, &u)
+	} else {
+		err = db.DefaultClient.Get(ctx, m.UserKey, &u)
+	}
+	if err == datastore.ErrNoSuchEntity {
+		// The message is quoted without its author
+		return &m, nil
+	} else if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	m.User = MapUserToUserPartial(&u)
+
+	return &m, nil
+}
+
+// newMessageReply returns what a reply to m quotes of it, or nil if m is
+// nil. Its user is that of m, which is nil if they have been deleted.
+func newMessageReply(m *Message) *MessageReply {
+	if m == nil {
+		return nil
+	}
+
+	body := []rune(m.Body)
+	if len(body) > _replyQuoteLength {
+		body = append(body[:_replyQuoteLength], '…')
+	}
+
+	return &MessageReply{
+		ID:        m.ID,
+		User:      m.User,
+		Body:      string(body),
+		Timestamp: m.Timestamp,
+	}
+}
+
 func removeLink(body string, linkPtr *og.LinkData) string {
 	if linkPtr == nil {
 		return body
@@ -306,3 +592,44 @@ func removeLink(body string, linkPtr *og.LinkData) string {
 
 	return strings.Replace(body, linkPtr.URL, "", 1)
 }
+
+// countReactions groups reactions by emoji, in the order in which each
+// emoji was first used.
+func countReactions(reactions []*Reaction) []*ReactionCount {
+	if len(reactions) == 0 {
+		return nil
+	}
+
+	var counts []*ReactionCount
+	byEmoji := make(map[string]*ReactionCount)
+	for _, r := range reactions {
+		c, ok := byEmoji[r.Emoji]
+		if !ok {
+			c = &ReactionCount{Emoji: r.Emoji, UserIDs: []string{}}
+			byEmoji[r.Emoji] = c
+			counts = append(counts, c)
+		}
+
+		c.Count++
+		c.UserIDs = append(c.UserIDs, r.UserKey.Encode())
+	}
+
+	return counts
+}
+
+// isEmoji is a loose check that s could be a single emoji. It rejects
+// anything long or containing letters or spaces, which is enough to keep
+// reactions from being used as messages.
+func isEmoji(s string) bool {
+	if s == "" || utf8.RuneCountInString(s) > _maxEmojiLength {
+		return false
+	}
+
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,5 +1,13 @@
 package models
 
+import (
+	"net/http"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/hiconvo/api/errors"
+)
+
 const (
 	_defaultPageNum = 0
 	_defaultPageSize = 10
@@ -7,9 +15,15 @@ const (
 
 // Pagination captures all info needed for pagination.
 // If Size is negative, the result is an unlimited size.
+//
+// Pages can be picked by number with Page, or by Cursor, which is faster
+// for later pages. Queries that support cursors set Next to the cursor of
+// the page after the one returned, or leave it empty if there isn't one.
 type Pagination struct {
-	Page int
-	Size int
+	Page   int
+	Size   int
+	Cursor string
+	Next   string
 }
 
 
@@ -33,3 +47,40 @@ func (p *Pagination) Offset() int {
 func (p *Pagination) Limit() int {
 	return p.getSize()
 }
+
+// apply limits q to the page that p refers to.
+func (p *Pagination) apply(q *datastore.Query) (*datastore.Query, error) {
+	if p.Cursor == "" {
+		return q.Offset(p.Offset()).Limit(p.Limit()), nil
+	}
+
+	cursor, err := datastore.DecodeCursor(p.Cursor)
+	if err != nil {
+		return q, errors.E(
+			errors.Op("models.Pagination.apply"),
+			map[string]string{"cursor": "Invalid cursor"},
+			http.StatusBadRequest,
+			err)
+	}
+
+	return q.Start(cursor).Limit(p.Limit()), nil
+}
+
+// setNext sets Next from it, which has been run to the end, if there may
+// be another page after the n results that it returned.
+func (p *Pagination) setNext(it *datastore.Iterator, n int) error {
+	p.Next = ""
+
+	if p.Limit() < 0 || n < p.Limit() {
+		return nil
+	}
+
+	cursor, err := it.Cursor()
+	if err != nil {
+		return err
+	}
+
+	p.Next = cursor.String()
+
+	return nil
+}
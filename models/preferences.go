@@ -0,0 +1,240 @@
+package models
+
+import (
+	"time"
+)
+
+// Notification preferences determine which emails a user receives about
+// new activity in their threads and events.
+const (
+	NotifyEveryMessage = "everyMessage"
+	NotifyDailyDigest  = "dailyDigest"
+	NotifyWeeklyDigest = "weeklyDigest"
+	NotifyNone         = "none"
+)
+
+// Email categories that users can unsubscribe from individually. These
+// appear in unsubscribe links, so they shouldn't be changed.
+const (
+	EmailCategoryDigest  = "digest"
+	EmailCategoryThreads = "threads"
+	EmailCategoryEvents  = "events"
+)
+
+// Weekly digests go out on the same day as the daily digest that would
+// otherwise have been sent.
+const _weeklyDigestDay = time.Sunday
+
+// Users who haven't picked a time get their digest at 19:00 UTC, which is
+// when digests went out for everyone before they could be scheduled.
+const (
+	_defaultTimezone   = "UTC"
+	_defaultDigestHour = 19
+)
+
+// IsTimezone returns true if name is an IANA time zone name, such as
+// "America/New_York", that can be loaded.
+func IsTimezone(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// IsDigestHour returns true if hour is a valid hour of the day.
+func IsDigestHour(hour int) bool {
+	return hour >= 0 && hour <= 23
+}
+
+// IsNotificationPreference returns true if pref is one of the supported
+// notification preferences.
+func IsNotificationPreference(pref string) bool {
+	switch pref {
+	case NotifyEveryMessage, NotifyDailyDigest, NotifyWeeklyDigest, NotifyNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsEmailCategory returns true if category is one of the email categories
+// that can be unsubscribed from.
+func IsEmailCategory(category string) bool {
+	switch category {
+	case EmailCategoryDigest, EmailCategoryThreads, EmailCategoryEvents:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsUnsubscribed returns true if the user has opted out of the given
+// category of emails.
+func (u *User) IsUnsubscribed(category string) bool {
+	for i := range u.Unsubscribed {
+		if u.Unsubscribed[i] == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unsubscribe opts the user out of the given category of emails.
+func (u *User) Unsubscribe(category string) {
+	if u.IsUnsubscribed(category) {
+		return
+	}
+
+	u.Unsubscribed = append(u.Unsubscribed, category)
+}
+
+// WantsMessageEmail returns true if the user should be emailed a new
+// message. The first message of a thread is emailed to everyone who
+// hasn't turned off email notifications entirely; later messages are
+// only emailed to those who asked for every message.
+func (u *User) WantsMessageEmail(isFirst bool) bool {
+	if u.IsUnsubscribed(EmailCategoryThreads) {
+		return false
+	}
+
+	switch u.NotificationPreference {
+	case NotifyNone:
+		return false
+	case NotifyEveryMessage:
+		return true
+	default:
+		return isFirst
+	}
+}
+
+// WantsMentionEmail returns true if the user should be emailed a message
+// that mentions them. Mentions are emailed right away, even to those who
+// otherwise only get digests. The category is that of the thread or event
+// the message belongs to.
+func (u *User) WantsMentionEmail(category string) bool {
+	return u.NotificationPreference != NotifyNone && !u.IsUnsubscribed(category)
+}
+
+// WantsEventEmails returns true if the user should receive invitations,
+// updates, and cancellations for events.
+func (u *User) WantsEventEmails() bool {
+	return u.NotificationPreference != NotifyNone && !u.IsUnsubscribed(EmailCategoryEvents)
+}
+
+// GetLocation returns the user's time zone. If it can't be loaded, UTC is
+// returned instead.
+func (u *User) GetLocation() *time.Location {
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// GetNextDigestAt returns the start of the first hour, from the one that
+// now falls in, during which a digest is due to the user. Digests are sent
+// at the user's preferred hour in their own time zone, and weekly digests
+// only on Sundays there. The current hour is skipped if their last digest
+// was sent during it. It returns the zero time if the user doesn't get
+// digests. Users who have just been created don't have their defaults yet,
+// so they are applied here too.
+func (u *User) GetNextDigestAt(now time.Time) time.Time {
+	if u.IsUnsubscribed(EmailCategoryDigest) {
+		return time.Time{}
+	}
+
+	pref := u.NotificationPreference
+	if pref == "" {
+		pref = NotifyDailyDigest
+	}
+
+	loc, hour := u.GetLocation(), u.DigestHour
+	if u.Timezone == "" {
+		loc, hour = time.UTC, _defaultDigestHour
+	}
+
+	t := now.Truncate(time.Hour)
+	if !u.LastDigestAt.Before(t) {
+		t = t.Add(time.Hour)
+	}
+
+	// A digest is due at least once a week
+	for i := 0; i < 8*24; i++ {
+		if isDigestDue(t, pref, loc, hour) {
+			return t
+		}
+		t = t.Add(time.Hour)
+	}
+
+	return time.Time{}
+}
+
+// isDigestDue returns true if a digest is due during the hour that t falls
+// in to a user with the given preference, time zone and digest hour.
+func isDigestDue(t time.Time, pref string, loc *time.Location, hour int) bool {
+	local := t.In(loc)
+	if local.Hour() != hour {
+		return false
+	}
+
+	switch pref {
+	case NotifyNone:
+		return false
+	case NotifyWeeklyDigest:
+		return local.Weekday() == _weeklyDigestDay
+	default:
+		return true
+	}
+}
+
+// GetEmailRecipients returns the members of the thread who should be
+// emailed m, its latest message.
+func (t *Thread) GetEmailRecipients(m *Message) []*User {
+	isFirst := t.ResponseCount <= 1
+
+	var recipients []*User
+	for _, u := range t.Users {
+		if m.OwnerIs(u) {
+			continue
+		}
+
+		if u.WantsMessageEmail(isFirst) || (m.IsMentioned(u) && u.WantsMentionEmail(EmailCategoryThreads)) {
+			recipients = append(recipients, u)
+		}
+	}
+
+	return recipients
+}
+
+// WasEmailedTo returns true if m, a message of the thread, was emailed to u
+// when it was sent. This is worked out from u's current preferences: the
+// first message of a thread, which is its preview, is emailed to more
+// members than the rest.
+func (t *Thread) WasEmailedTo(m *Message, u *User) bool {
+	isFirst := t.Preview != nil && t.Preview.Timestamp.Equal(m.Timestamp)
+	return u.WantsMessageEmail(isFirst) || (m.IsMentioned(u) && u.WantsMentionEmail(EmailCategoryThreads))
+}
+
+// GetMentionRecipients returns the members of the event who should be
+// emailed m, its latest message. Event messages are only emailed to those
+// they mention.
+func (e *Event) GetMentionRecipients(m *Message) []*User {
+	var recipients []*User
+	for _, u := range e.Users {
+		if !m.OwnerIs(u) && m.IsMentioned(u) && u.WantsMentionEmail(EmailCategoryEvents) {
+			recipients = append(recipients, u)
+		}
+	}
+
+	return recipients
+}
+
+// WasEmailedTo returns true if m, a message of the event, was emailed to u
+// when it was sent.
+func (e *Event) WasEmailedTo(m *Message, u *User) bool {
+	return m.IsMentioned(u) && u.WantsMentionEmail(EmailCategoryEvents)
+}
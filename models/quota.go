@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/hiconvo/api/db"
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/log"
+)
+
+// CheckStorageQuota returns a client error, reported under field, if size
+// more bytes of files would take u over their storage quota, or a thread or
+// event that already stores parentUsed bytes over its own.
+func CheckStorageQuota(u *User, parentUsed, size int, field string) error {
+	op := errors.Op("models.CheckStorageQuota")
+
+	if size == 0 {
+		return nil
+	}
+
+	if u.StorageUsed+size > _userStorageQuota {
+		return errors.E(op, errors.Str("UserOverQuota"),
+			map[string]string{field: fmt.Sprintf("This would take you over your %s of storage", formatBytes(_userStorageQuota))},
+			http.StatusBadRequest)
+	}
+
+	if parentUsed+size > _convoStorageQuota {
+		return errors.E(op, errors.Str("ConvoOverQuota"),
+			map[string]string{field: fmt.Sprintf("This would take the conversation over its %s of storage", formatBytes(_convoStorageQuota))},
+			http.StatusBadRequest)
+	}
+
+	return nil
+}
+
+// GetStorageUsage reads the user with userKey and the thread or event with
+// parentKey again, within the transaction in ctx, and returns the user along
+// with how many bytes the thread or event stores. Quotas are checked and
+// updated against these rather than against copies that were loaded earlier
+// in the request, which may be stale by the time the message is saved.
+func GetStorageUsage(ctx context.Context, userKey, parentKey *datastore.Key) (*User, int, error) {
+	op := errors.Op("models.GetStorageUsage")
+
+	var u User
+	if err := db.DefaultClient.Get(ctx, userKey, &u); err != nil {
+		return nil, 0, errors.E(op, err)
+	}
+
+	switch parentKey.Kind {
+	case "Thread":
+		var t Thread
+		if err := db.DefaultClient.Get(ctx, parentKey, &t); err != nil {
+			return nil, 0, errors.E(op, err)
+		}
+
+		return &u, t.StorageUsed, nil
+	case "Event":
+		var e Event
+		if err := db.DefaultClient.Get(ctx, parentKey, &e); err != nil {
+			return nil, 0, errors.E(op, err)
+		}
+
+		return &u, e.StorageUsed, nil
+	default:
+		return nil, 0, errors.E(op, errors.Errorf("unexpected parent kind '%s'", parentKey.Kind))
+	}
+}
+
+// StoredBytes returns how many bytes of photos and attachments were sent
+// with the message. It counts the files as they were sent, not the sizes
+// that photos are saved in.
+func (m *Message) StoredBytes() int {
+	var size int
+	for _, n := range m.PhotoBytes {
+		size += n
+	}
+
+	for _, a := range m.Attachments {
+		size += a.Size
+	}
+
+	return size
+}
+
+func (u *User) AddStorageUsed(n int) {
+	u.StorageUsed = addStorageUsed(u.StorageUsed, n)
+}
+
+func (t *Thread) AddStorageUsed(n int) {
+	t.StorageUsed = addStorageUsed(t.StorageUsed, n)
+}
+
+func (e *Event) AddStorageUsed(n int) {
+	e.StorageUsed = addStorageUsed(e.StorageUsed, n)
+}
+
+// ReleaseStorage takes the files of the given messages off of the storage
+// used by their authors, once the thread or event they were in has been
+// deleted. Failures are only logged since the deletion already happened.
+func ReleaseStorage(ctx context.Context, messages []*Message) {
+	op := errors.Op("models.ReleaseStorage")
+
+	released := make(map[string]int)
+	var keys []*datastore.Key
+	for _, m := range messages {
+		size := m.StoredBytes()
+		if size == 0 {
+			continue
+		}
+
+		id := m.UserKey.Encode()
+		if _, ok := released[id]; !ok {
+			keys = append(keys, m.UserKey)
+		}
+		released[id] += size
+	}
+
+	if len(keys) == 0 {
+		return
+	}
+
+	// The authors are read and written in a transaction so that files that
+	// they send meanwhile aren't lost from their usage
+	if _, err := db.DefaultClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		users := make([]*User, len(keys))
+		found := keys
+		if err := tx.GetMulti(keys, users); err != nil {
+			// Authors who have since been deleted are skipped
+			multiErr, ok := err.(datastore.MultiError)
+			if !ok {
+				return err
+			}
+
+			var foundUsers []*User
+			found = nil
+			for i := range users {
+				if multiErr[i] == nil {
+					foundUsers = append(foundUsers, users[i])
+					found = append(found, keys[i])
+				} else if multiErr[i] != datastore.ErrNoSuchEntity {
+					return multiErr[i]
+				}
+			}
+			users = foundUsers
+		}
+
+		for i, u := range users {
+			u.AddStorageUsed(-released[found[i].Encode()])
+		}
+
+		_, err := tx.PutMulti(found, users)
+		return err
+	}); err != nil {
+		log.Alarm(errors.E(op, err))
+	}
+}
+
+// addStorageUsed returns used plus n, which is never less than zero since
+// files that were sent before usage was tracked were never added.
+func addStorageUsed(used, n int) int {
+	if used+n < 0 {
+		return 0
+	}
+
+	return used + n
+}
+
+// formatBytes returns n in the largest of MB or GB that it is a whole
+// number of, which quotas always are.
+func formatBytes(n int) string {
+	if n >= 1<<30 && n%(1<<30) == 0 {
+		return fmt.Sprintf("%d GB", n>>30)
+	}
+
+	return fmt.Sprintf("%d MB", n>>20)
+}
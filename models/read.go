@@ -1,48 +1,329 @@
 package models
 
 import (
+	"context"
 	"time"
 
 	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/hiconvo/api/db"
 )
 
+// Read is a user's read cursor for a thread or event. It records when the
+// user last read it; everything before then counts as read. There is at
+// most one per user per thread or event. It is stored as a child of the
+// thread or event and named after the user, so marking something as read
+// is a single put however many messages it has.
 type Read struct {
+	Key       *datastore.Key `datastore:"__key__"`
 	UserKey   *datastore.Key
-	Timestamp time.Time
+	Timestamp time.Time `datastore:",noindex"`
 }
 
 type Readable interface {
+	GetKey() *datastore.Key
+	GetUpdatedAt() time.Time
 	GetReads() []*Read
 	SetReads([]*Read)
 }
 
-func NewRead(userKey *datastore.Key) *Read {
-	return &Read{
-		UserKey:   userKey,
-		Timestamp: time.Now(),
+func newReadKey(parentKey, userKey *datastore.Key) *datastore.Key {
+	return datastore.NameKey("Read", userKey.Encode(), parentKey)
+}
+
+// MarkAsRead moves the read cursors of the given users for r to now and
+// saves them. If ctx has a transaction that is still pending, they are
+// saved with it and the caller has to commit it.
+func MarkAsRead(ctx context.Context, r Readable, userKeys ...*datastore.Key) error {
+	now := time.Now()
+	keys := make([]*datastore.Key, len(userKeys))
+	reads := make([]*Read, len(userKeys))
+	for i := range userKeys {
+		keys[i] = newReadKey(r.GetKey(), userKeys[i])
+		reads[i] = &Read{Key: keys[i], UserKey: userKeys[i], Timestamp: now}
+	}
+
+	if tx, ok := db.TransactionFromContext(ctx); ok && tx.Pending() {
+		if _, err := db.DefaultClient.PutMultiWithTransaction(ctx, keys, reads); err != nil {
+			return err
+		}
+	} else if _, err := db.DefaultClient.PutMulti(ctx, keys, reads); err != nil {
+		return err
+	}
+
+	// Replace the cursors that r already had for these users.
+	for _, read := range r.GetReads() {
+		if !hasReadByUser(reads, read.UserKey) {
+			reads = append(reads, read)
+		}
+	}
+
+	r.SetReads(reads)
+
+	return nil
+}
+
+// GetReadTime returns when the user last read r, or the zero time if they
+// never have. The reads of r must have been loaded.
+func GetReadTime(r Readable, userKey *datastore.Key) time.Time {
+	for _, read := range r.GetReads() {
+		if read.UserKey.Equal(userKey) {
+			return read.Timestamp
+		}
+	}
+
+	return time.Time{}
+}
+
+// IsRead returns true if the user has read r since it was last updated.
+// The reads of r must have been loaded.
+func IsRead(r Readable, userKey *datastore.Key) bool {
+	for _, read := range r.GetReads() {
+		if read.UserKey.Equal(userKey) {
+			return !read.Timestamp.Before(r.GetUpdatedAt())
+		}
+	}
+
+	return false
+}
+
+func MapReadsToUserPartials(r Readable, users []*User) []*UserPartial {
+	var userPartials []*UserPartial
+	for i := range users {
+		if IsRead(r, users[i].Key) {
+			userPartials = append(userPartials, MapUserToUserPartial(users[i]))
+		}
+	}
+
+	return userPartials
+}
+
+// SetReadBy sets who has read each of the messages of r, going by the
+// read cursors of r, which must have been loaded. Authors aren't included
+// for their own messages. Users who hide their read receipts are left out
+// unless they are the viewer.
+func SetReadBy(messages []*Message, r Readable, users []*User, viewer *User) {
+	for _, m := range messages {
+		m.ReadBy = []*UserPartial{}
+
+		for _, u := range users {
+			if m.UserKey.Equal(u.Key) {
+				continue
+			}
+
+			if u.HideReadReceipts && !u.Key.Equal(viewer.Key) {
+				continue
+			}
+
+			if !GetReadTime(r, u.Key).Before(m.Timestamp) {
+				m.ReadBy = append(m.ReadBy, MapUserToUserPartial(u))
+			}
+		}
 	}
 }
 
-func MarkAsRead(r Readable, userKey *datastore.Key) {
-	reads := r.GetReads()
+// Unread messages are counted up to _maxUnreadCount; any more are reported
+// as _maxUnreadCount+1, to be shown as "99+". Unread counts by user only
+// look at the _maxUnreadConversations most recently updated threads and
+// events of each kind.
+const (
+	_maxUnreadCount         = 99
+	_maxUnreadConversations = 50
+)
+
+// UnreadCounts summarizes what a user hasn't read. Threads and Events map
+// the IDs of the unread ones to how many of their messages are unread.
+type UnreadCounts struct {
+	Total   int            `json:"total"`
+	Threads map[string]int `json:"threads"`
+	Events  map[string]int `json:"events"`
+}
 
-	// If this has already been read, skip it
+// GetUnreadCount returns how many messages of r the user hasn't read, up
+// to _maxUnreadCount+1. The user's read cursor for r must have been loaded.
+func GetUnreadCount(ctx context.Context, r Readable, userKey *datastore.Key) (int, error) {
 	if IsRead(r, userKey) {
-		return
+		return 0, nil
+	}
+
+	q := datastore.NewQuery("Message").
+		Filter("ParentKey =", r.GetKey()).
+		Filter("Timestamp >", GetReadTime(r, userKey)).
+		KeysOnly().
+		Limit(_maxUnreadCount + 1)
+
+	keys, err := db.DefaultClient.GetAll(ctx, q, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
+
+// GetUnreadCountsByUser returns the threads and events of which the user is
+// a member that they haven't read, along with their unread message counts.
+// Only the most recently updated threads and events are looked at.
+func GetUnreadCountsByUser(ctx context.Context, u *User) (UnreadCounts, error) {
+	counts := UnreadCounts{
+		Threads: make(map[string]int),
+		Events:  make(map[string]int),
+	}
+
+	var threads []*Thread
+	q := datastore.NewQuery("Thread").
+		Filter("UserKeys =", u.Key).
+		Order("-UpdatedAt").
+		Limit(_maxUnreadConversations)
+	if _, err := db.DefaultClient.GetAll(ctx, q, &threads); err != nil {
+		return counts, err
+	}
+
+	var events []*Event
+	q = datastore.NewQuery("Event").
+		Filter("UserKeys =", u.Key).
+		Order("-UpdatedAt").
+		Limit(_maxUnreadConversations)
+	if _, err := db.DefaultClient.GetAll(ctx, q, &events); err != nil {
+		return counts, err
+	}
+
+	readables := make([]Readable, 0, len(threads)+len(events))
+	for i := range threads {
+		readables = append(readables, threads[i])
+	}
+	for i := range events {
+		readables = append(readables, events[i])
+	}
+
+	readUserKeys := make([][]*datastore.Key, len(readables))
+	for i := range readables {
+		readUserKeys[i] = []*datastore.Key{u.Key}
+	}
+
+	if err := loadReads(ctx, readables, readUserKeys); err != nil {
+		return counts, err
+	}
+
+	for i := range threads {
+		if IsRead(threads[i], u.Key) {
+			continue
+		}
+
+		n, err := GetUnreadCount(ctx, threads[i], u.Key)
+		if err != nil {
+			return counts, err
+		}
+
+		counts.Threads[threads[i].ID] = n
+	}
+
+	for i := range events {
+		if IsRead(events[i], u.Key) {
+			continue
+		}
+
+		n, err := GetUnreadCount(ctx, events[i], u.Key)
+		if err != nil {
+			return counts, err
+		}
+
+		counts.Events[events[i].ID] = n
+	}
+
+	counts.Total = len(counts.Threads) + len(counts.Events)
+
+	return counts, nil
+}
+
+// loadReads sets the reads of the given users on each readable. The users
+// whose reads are loaded for readables[i] are userKeys[i]. It gets all of
+// the reads in one go, so it must not be used inside a transaction, where
+// a missing read would roll it back.
+func loadReads(ctx context.Context, readables []Readable, userKeys [][]*datastore.Key) error {
+	var keys []*datastore.Key
+	var idxs []int
+	for i := range readables {
+		for j := range userKeys[i] {
+			keys = append(keys, newReadKey(readables[i].GetKey(), userKeys[i][j]))
+			idxs = append(idxs, i)
+		}
+	}
+
+	reads := make([]*Read, len(keys))
+	err := db.DefaultClient.GetMulti(ctx, keys, reads)
+	merr, isMultiErr := err.(datastore.MultiError)
+	if err != nil && !isMultiErr {
+		return err
+	}
+
+	found := make([][]*Read, len(readables))
+	for i := range reads {
+		if isMultiErr && merr[i] != nil {
+			if merr[i] == datastore.ErrNoSuchEntity {
+				continue
+			}
+			return merr[i]
+		}
+
+		found[idxs[i]] = append(found[idxs[i]], reads[i])
+	}
+
+	for i := range readables {
+		readables[i].SetReads(found[i])
 	}
 
-	reads = append(reads, NewRead(userKey))
+	return nil
+}
+
+// loadReadsByParent sets all of the reads of r on it. Unlike loadReads, it
+// is safe to use inside a transaction.
+func loadReadsByParent(ctx context.Context, r Readable) error {
+	var reads []*Read
+	q := datastore.NewQuery("Read").Ancestor(r.GetKey())
+	if _, err := db.DefaultClient.GetAll(ctx, q, &reads); err != nil {
+		return err
+	}
 
 	r.SetReads(reads)
+
+	return nil
 }
 
-func ClearReads(r Readable) {
-	r.SetReads([]*Read{})
+// getReadKeysByParent returns the keys of all of the reads of the thread or
+// event with the given key, so that they can be deleted along with it.
+func getReadKeysByParent(ctx context.Context, parentKey *datastore.Key) ([]*datastore.Key, error) {
+	q := datastore.NewQuery("Read").Ancestor(parentKey).KeysOnly()
+	return db.DefaultClient.GetAll(ctx, q, nil)
 }
 
-func IsRead(r Readable, userKey *datastore.Key) bool {
-	reads := r.GetReads()
+// loadLegacyReads decodes the value of the Reads property that threads,
+// events, and messages had before reads were stored in their own entities.
+func loadLegacyReads(v interface{}) []*Read {
+	values, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	reads := make([]*Read, 0, len(values))
+	for i := range values {
+		e, ok := values[i].(*datastore.Entity)
+		if !ok {
+			continue
+		}
 
+		var read Read
+		if err := datastore.LoadStruct(&read, e.Properties); err != nil || read.UserKey == nil {
+			continue
+		}
+
+		reads = append(reads, &read)
+	}
+
+	return reads
+}
+
+func hasReadByUser(reads []*Read, userKey *datastore.Key) bool {
 	for i := range reads {
 		if reads[i].UserKey.Equal(userKey) {
 			return true
@@ -52,17 +333,106 @@ func IsRead(r Readable, userKey *datastore.Key) bool {
 	return false
 }
 
-func MapReadsToUserPartials(r Readable, users []*User) []*UserPartial {
-	reads := r.GetReads()
-	var userPartials []*UserPartial
-	for i := range reads {
-		for j := range users {
-			if users[j].Key.Equal(reads[i].UserKey) {
-				userPartials = append(userPartials, MapUserToUserPartial(users[j]))
-				break
-			}
+// ReadMigration counts the entities whose legacy reads MigrateReads moved.
+type ReadMigration struct {
+	Threads  int `json:"threads"`
+	Events   int `json:"events"`
+	Messages int `json:"messages"`
+}
+
+// MigrateReads moves the reads that used to be stored on threads and
+// events into read cursors, and drops those that used to be stored on
+// messages. Migrated entities are saved without their reads, so it can be
+// run again, for example after a timeout, and picks up where it stopped.
+func MigrateReads(ctx context.Context) (ReadMigration, error) {
+	var m ReadMigration
+
+	threads := db.DefaultClient.Run(ctx, datastore.NewQuery("Thread"))
+	for {
+		var t Thread
+		_, err := threads.Next(&t)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return m, err
+		}
+
+		if t.legacyReads == nil {
+			continue
+		}
+
+		if err := putLegacyReads(ctx, &t, t.legacyReads); err != nil {
+			return m, err
+		}
+
+		if err := t.Commit(ctx); err != nil {
+			return m, err
 		}
+
+		m.Threads++
 	}
 
-	return userPartials
+	events := db.DefaultClient.Run(ctx, datastore.NewQuery("Event"))
+	for {
+		var e Event
+		_, err := events.Next(&e)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return m, err
+		}
+
+		if e.legacyReads == nil {
+			continue
+		}
+
+		if err := putLegacyReads(ctx, &e, e.legacyReads); err != nil {
+			return m, err
+		}
+
+		if err := e.Commit(ctx); err != nil {
+			return m, err
+		}
+
+		m.Events++
+	}
+
+	messages := db.DefaultClient.Run(ctx, datastore.NewQuery("Message"))
+	for {
+		var msg Message
+		_, err := messages.Next(&msg)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return m, err
+		}
+
+		if !msg.hasLegacyReads {
+			continue
+		}
+
+		if err := msg.Commit(ctx); err != nil {
+			return m, err
+		}
+
+		m.Messages++
+	}
+
+	return m, nil
+}
+
+// putLegacyReads saves the legacy reads of r as read cursors. Legacy reads
+// were cleared whenever a message was added, so a user who has one had
+// read everything in r and their cursor is moved to now.
+func putLegacyReads(ctx context.Context, r Readable, legacyReads []*Read) error {
+	if len(legacyReads) == 0 {
+		return nil
+	}
+
+	userKeys := make([]*datastore.Key, len(legacyReads))
+	for i := range legacyReads {
+		userKeys[i] = legacyReads[i].UserKey
+	}
+
+	return MarkAsRead(ctx, r, userKeys...)
 }
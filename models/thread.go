@@ -8,10 +8,12 @@ import (
 
 	"cloud.google.com/go/datastore"
 	"github.com/gosimple/slug"
+	"google.golang.org/api/iterator"
 
 	"github.com/hiconvo/api/db"
 	"github.com/hiconvo/api/errors"
 	"github.com/hiconvo/api/queue"
+	"github.com/hiconvo/api/utils/magic"
 )
 
 type Thread struct {
@@ -25,9 +27,13 @@ type Thread struct {
 	Subject       string           `json:"subject"  datastore:",noindex"`
 	Preview       *Message         `json:"preview"  datastore:",noindex"`
 	UserReads     []*UserPartial   `json:"reads"    datastore:"-"`
-	Reads         []*Read          `json:"-"        datastore:",noindex"`
+	Reads         []*Read          `json:"-"        datastore:"-"`
 	CreatedAt     time.Time        `json:"-"`
+	UpdatedAt     time.Time        `json:"-"`
 	ResponseCount int              `json:"responseCount" datastore:",noindex"`
+	StorageUsed   int              `json:"-"        datastore:",noindex"`
+	UnreadCount   int              `json:"unreadCount" datastore:"-"`
+	legacyReads   []*Read
 }
 
 func NewThread(subject string, owner *User, users []*User) (Thread, error) {
@@ -71,13 +77,6 @@ func NewThread(subject string, owner *User, users []*User) (Thread, error) {
 		}
 	}
 
-	// Since an email is sent when a thread is created,
-	// it is initialized as having been read by all members.
-	reads := make([]*Read, len(userKeys))
-	for i := range users {
-		reads[i] = NewRead(userKeys[i])
-	}
-
 	return Thread{
 		Key:          datastore.IncompleteKey("Thread", nil),
 		OwnerKey:     owner.Key,
@@ -86,7 +85,6 @@ func NewThread(subject string, owner *User, users []*User) (Thread, error) {
 		Users:        users,
 		UserPartials: MapUsersToUserPartials(users),
 		Subject:      subject,
-		Reads:        reads,
 	}, nil
 }
 
@@ -106,7 +104,7 @@ func (t *Thread) Save() ([]datastore.Property, error) {
 func (t *Thread) Load(ps []datastore.Property) error {
 	if err := datastore.LoadStruct(t, ps); err != nil {
 		if mismatch, ok := err.(*datastore.ErrFieldMismatch); ok {
-			if mismatch.FieldName != "Preview" {
+			if mismatch.FieldName != "Preview" && mismatch.FieldName != "Reads" {
 				return err
 			}
 		} else {
@@ -125,6 +123,10 @@ func (t *Thread) Load(ps []datastore.Property) error {
 				}
 			}
 		}
+
+		if p.Name == "Reads" {
+			t.legacyReads = loadLegacyReads(p.Value)
+		}
 	}
 
 	return nil
@@ -135,6 +137,10 @@ func (t *Thread) Commit(ctx context.Context) error {
 		t.CreatedAt = time.Now()
 	}
 
+	if t.UpdatedAt.IsZero() {
+		t.UpdatedAt = t.CreatedAt
+	}
+
 	key, err := db.DefaultClient.Put(ctx, t.Key, t)
 	if err != nil {
 		return errors.E(errors.Op("thread.Commit"), err)
@@ -150,8 +156,14 @@ func (t *Thread) CommitWithTransaction(tx db.Transaction) (*datastore.PendingKey
 	return tx.Put(t.Key, t)
 }
 
+// Delete deletes the thread along with its read cursors.
 func (t *Thread) Delete(ctx context.Context) error {
-	if err := db.DefaultClient.Delete(ctx, t.Key); err != nil {
+	keys, err := getReadKeysByParent(ctx, t.Key)
+	if err != nil {
+		return err
+	}
+
+	if err := db.DefaultClient.DeleteMulti(ctx, append(keys, t.Key)); err != nil {
 		return err
 	}
 	return nil
@@ -169,16 +181,22 @@ func (t *Thread) GetKey() *datastore.Key {
 	return t.Key
 }
 
+func (t *Thread) GetUpdatedAt() time.Time {
+	return t.UpdatedAt
+}
+
 func (t *Thread) GetName() string {
 	return t.Subject
 }
 
-func (t *Thread) GetEmail() string {
+// GetReplyEmail returns the address from which the thread is emailed to
+// the given user. Replies to it are attributed to that user.
+func (t *Thread) GetReplyEmail(u *User) string {
 	slugified := slug.Make(t.Subject)
 	if len(slugified) > 20 {
 		slugified = slugified[:20]
 	}
-	return fmt.Sprintf("%s-%d@mail.convo.events", slugified, t.Key.ID)
+	return fmt.Sprintf("%s-%s@mail.convo.events", slugified, magic.NewReplyToken(t.Key, u.Key))
 }
 
 func (t *Thread) HasUser(u *User) bool {
@@ -248,8 +266,39 @@ func (t *Thread) RemoveUser(u *User) {
 	}
 }
 
+// UpdatePreview replaces the preview of the thread with m if m is the
+// message it was made from, as it is after m has been edited. It returns
+// true if the preview changed.
+func (t *Thread) UpdatePreview(m *Message) bool {
+	if t.Preview == nil || !t.Preview.Timestamp.Equal(m.Timestamp) {
+		return false
+	}
+
+	preview := *m
+	preview.Edits = nil
+	preview.ReadBy = nil
+	preview.Reactions = nil
+	preview.ReactionCounts = nil
+	t.Preview = &preview
+
+	return true
+}
+
+// SignThreadPreviews signs the URLs of the photos and attachments of the
+// previews of the given threads. See SignMessageURLs.
+func SignThreadPreviews(ctx context.Context, threads []*Thread) error {
+	var previews []*Message
+	for _, t := range threads {
+		if t.Preview != nil {
+			previews = append(previews, t.Preview)
+		}
+	}
+
+	return SignMessageURLs(ctx, previews)
+}
+
 func (t *Thread) Send(ctx context.Context) error {
-	messages, err := GetMessagesByThread(ctx, t)
+	messages, err := GetMessagesByThread(ctx, t, &Pagination{Size: -1})
 	if err != nil {
 		return err
 	}
@@ -292,17 +341,30 @@ func GetThreadByInt64ID(ctx context.Context, id int64) (Thread, error) {
 func GetUnhydratedThreadsByUser(ctx context.Context, u *User, p *Pagination) ([]*Thread, error) {
 	var threads []*Thread
 
-	q := datastore.NewQuery("Thread").
+	q, err := p.apply(datastore.NewQuery("Thread").
 		Filter("UserKeys =", u.Key).
-		Order("-CreatedAt").
-		Offset(p.Offset()).
-		Limit(p.Limit())
-
-	_, err := db.DefaultClient.GetAll(ctx, q, &threads)
+		Order("-CreatedAt"))
 	if err != nil {
 		return threads, err
 	}
 
+	it := db.DefaultClient.Run(ctx, q)
+	for {
+		var t Thread
+		_, err := it.Next(&t)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return threads, err
+		}
+
+		threads = append(threads, &t)
+	}
+
+	if err := p.setNext(it, len(threads)); err != nil {
+		return threads, err
+	}
+
 	return threads, nil
 }
 
@@ -329,6 +391,18 @@ func GetThreadsByUser(ctx context.Context, u *User, p *Pagination) ([]*Thread, e
 		return threads, err
 	}
 
+	// We get the members' read cursors in one go too.
+	readables := make([]Readable, len(threads))
+	readUserKeys := make([][]*datastore.Key, len(threads))
+	for i := range threads {
+		readables[i] = threads[i]
+		readUserKeys[i] = threads[i].UserKeys
+	}
+
+	if err := loadReads(ctx, readables, readUserKeys); err != nil {
+		return threads, err
+	}
+
 	// We add the just retrieved user objects to their corresponding threads by
 	// iterating through all of the threads and assigning their users according
 	// to the index which we created above.
@@ -353,6 +427,12 @@ func GetThreadsByUser(ctx context.Context, u *User, p *Pagination) ([]*Thread, e
 		threads[i].UserPartials = MapUsersToUserPartials(threadUsers)
 		threads[i].UserReads = MapReadsToUserPartials(threads[i], threadUsers)
 
+		unreadCount, err := GetUnreadCount(ctx, threads[i], u.Key)
+		if err != nil {
+			return threads, err
+		}
+		threads[i].UnreadCount = unreadCount
+
 		start += idxs[i]
 		threadPtrs[i] = threads[i]
 	}
@@ -380,6 +460,10 @@ func handleGetThread(ctx context.Context, key *datastore.Key, t Thread) (Thread,
 		}
 	}
 
+	if err := loadReadsByParent(ctx, &t); err != nil {
+		return t, err
+	}
+
 	t.Users = userPointers
 	t.UserPartials = MapUsersToUserPartials(userPointers)
 	t.UserReads = MapReadsToUserPartials(&t, userPointers)
@@ -0,0 +1,333 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/hiconvo/api/db"
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/log"
+	"github.com/hiconvo/api/storage"
+)
+
+// MaxUploadSize bounds the size of an upload of any type, in bytes.
+const MaxUploadSize = 10 << 20
+
+// _photoTypes are the content types of the images that can be uploaded as
+// photos and avatars.
+var _photoTypes = map[string]struct{}{
+	"image/jpeg": {},
+	"image/png":  {},
+	"image/gif":  {},
+	"image/webp": {},
+}
+
+// Upload is a file that was uploaded before the message or avatar it is
+// for, so that it doesn't have to be sent as base64 inside of JSON. It can
+// be sent whole or in parts, in which case Received is how much of it has
+// been saved so far. Once it is complete, it can be attached by its ID.
+type Upload struct {
+	Key         *datastore.Key `json:"-"           datastore:"__key__"`
+	ID          string         `json:"id"          datastore:"-"`
+	UserKey     *datastore.Key `json:"-"`
+	Name        string         `json:"name"        datastore:",noindex"`
+	ContentType string         `json:"contentType" datastore:",noindex"`
+	Size        int            `json:"size"        datastore:",noindex"`
+	Received    int            `json:"received"    datastore:",noindex"`
+	Complete    bool           `json:"complete"    datastore:",noindex"`
+	BlobKey     string         `json:"-"           datastore:",noindex"`
+	Parts       int            `json:"-"           datastore:",noindex"`
+	CreatedAt   time.Time      `json:"createdAt"`
+}
+
+// NewUpload returns a new upload of a file with the given name, content
+// type, and size. If the size isn't known yet, it should be zero.
+func NewUpload(u *User, name, contentType string, size int) (Upload, error) {
+	op := errors.Op("models.NewUpload")
+
+	if size < 0 {
+		return Upload{}, errors.E(op, errors.Str("InvalidSize"),
+			map[string]string{"size": "Invalid size"},
+			http.StatusBadRequest)
+	}
+
+	if isPhotoType(contentType) {
+		if size > MaxPhotoSize {
+			return Upload{}, errors.E(op, errors.Str("TooLarge"),
+				map[string]string{"size": "Photos must be smaller than 10 MB"},
+				http.StatusBadRequest)
+		}
+	} else if err := ValidateAttachment(name, contentType, size); err != nil {
+		return Upload{}, errors.E(op, err)
+	}
+
+	return Upload{
+		Key:         datastore.IncompleteKey("Upload", nil),
+		UserKey:     u.Key,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		BlobKey:     storage.DefaultClient.NewUploadKey(name),
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+func (up *Upload) LoadKey(k *datastore.Key) error {
+	up.Key = k
+
+	// Add URL safe key
+	if k != nil {
+		up.ID = k.Encode()
+	}
+
+	return nil
+}
+
+func (up *Upload) Save() ([]datastore.Property, error) {
+	return datastore.SaveStruct(up)
+}
+
+func (up *Upload) Load(ps []datastore.Property) error {
+	return datastore.LoadStruct(up, ps)
+}
+
+func (up *Upload) OwnerIs(u *User) bool {
+	return up.UserKey.Equal(u.Key)
+}
+
+// IsPhoto returns true if the upload is an image that can be used as a
+// photo or avatar. Other uploads can only be attached to messages.
+func (up *Upload) IsPhoto() bool {
+	return isPhotoType(up.ContentType)
+}
+
+// Put saves everything that is read from r as the whole of the upload. If
+// the size of the upload wasn't known, it is set to what was read.
+func (up *Upload) Put(ctx context.Context, r io.Reader) error {
+	op := errors.Op("models.Upload.Put")
+
+	if up.Complete {
+		return errors.E(op, errors.Str("AlreadyComplete"),
+			map[string]string{"upload": "The upload is already complete"},
+			http.StatusBadRequest)
+	}
+
+	// Parts that were already received would be left behind
+	if up.Received > 0 {
+		return errors.E(op, errors.Str("OutOfOrder"),
+			map[string]string{"upload": fmt.Sprintf("The upload continues from byte %d", up.Received)},
+			http.StatusConflict)
+	}
+
+	limit := up.Size
+	if limit == 0 {
+		limit = up.maxSize()
+	}
+
+	lr := &limitedReader{r: r, n: int64(limit)}
+	n, err := storage.DefaultClient.PutUpload(ctx, up.BlobKey, lr)
+	if lr.exceeded {
+		return errors.E(op, errors.Str("TooLarge"),
+			map[string]string{"upload": "The upload is too large"},
+			http.StatusBadRequest)
+	} else if err != nil {
+		return errors.E(op, err)
+	}
+
+	if n == 0 || (up.Size != 0 && int(n) != up.Size) {
+		return errors.E(op, errors.Str("Incomplete"),
+			map[string]string{"upload": "The upload is smaller than expected"},
+			http.StatusBadRequest)
+	}
+
+	up.Size = int(n)
+	up.Received = up.Size
+	up.Complete = true
+
+	return nil
+}
+
+// PutPart saves what is read from r as the next part of the upload, which
+// is the bytes from start to end, inclusive. It must start where the last
+// part ended, and exactly that many bytes must be read. The upload is
+// complete once all of its bytes have been received.
+func (up *Upload) PutPart(ctx context.Context, r io.Reader, start, end int) error {
+	op := errors.Op("models.Upload.PutPart")
+
+	if up.Complete {
+		return errors.E(op, errors.Str("AlreadyComplete"),
+			map[string]string{"upload": "The upload is already complete"},
+			http.StatusBadRequest)
+	}
+
+	if start != up.Received {
+		return errors.E(op, errors.Str("OutOfOrder"),
+			map[string]string{"upload": fmt.Sprintf("The upload continues from byte %d", up.Received)},
+			http.StatusConflict)
+	}
+
+	if end < start || end >= up.Size {
+		return errors.E(op, errors.Str("InvalidRange"),
+			map[string]string{"upload": "Invalid Content-Range"},
+			http.StatusBadRequest)
+	}
+
+	// A part that doesn't match its range isn't counted, so the next part
+	// to be saved replaces it
+	size := end - start + 1
+	lr := &limitedReader{r: r, n: int64(size)}
+	n, err := storage.DefaultClient.PutUploadPart(ctx, up.BlobKey, up.Parts, lr)
+	if lr.exceeded {
+		return errors.E(op, errors.Str("TooLarge"),
+			map[string]string{"upload": "The part is larger than its Content-Range"},
+			http.StatusBadRequest)
+	} else if err != nil {
+		return errors.E(op, err)
+	}
+
+	if int(n) != size {
+		return errors.E(op, errors.Str("Incomplete"),
+			map[string]string{"upload": "The part is smaller than its Content-Range"},
+			http.StatusBadRequest)
+	}
+
+	up.Received += int(n)
+	up.Parts++
+
+	if up.Received == up.Size {
+		if err := storage.DefaultClient.CombineUploadParts(ctx, up.BlobKey, up.Parts); err != nil {
+			return errors.E(op, err)
+		}
+
+		up.Parts = 0
+		up.Complete = true
+	}
+
+	return nil
+}
+
+func (up *Upload) Commit(ctx context.Context) error {
+	key, err := db.DefaultClient.Put(ctx, up.Key, up)
+	if err != nil {
+		return err
+	}
+
+	up.ID = key.Encode()
+	up.Key = key
+
+	return nil
+}
+
+// Delete deletes the upload along with what was saved of it.
+func (up *Upload) Delete(ctx context.Context) error {
+	if err := storage.DefaultClient.DeleteUpload(ctx, up.BlobKey, up.Parts); err != nil {
+		return err
+	}
+
+	if err := db.DefaultClient.Delete(ctx, up.Key); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// maxSize returns the largest that the upload can be given its content
+// type.
+func (up *Upload) maxSize() int {
+	if up.IsPhoto() {
+		return MaxPhotoSize
+	}
+
+	return MaxAttachmentSize
+}
+
+func GetUploadByID(ctx context.Context, id string) (Upload, error) {
+	var op errors.Op = "models.GetUploadByID"
+	var upload Upload
+
+	key, err := datastore.DecodeKey(id)
+	if err != nil {
+		return upload, errors.E(op, err, http.StatusNotFound)
+	}
+
+	if err := db.DefaultClient.Get(ctx, key, &upload); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return upload, errors.E(op, err, http.StatusNotFound)
+		}
+
+		return upload, errors.E(op, err)
+	}
+
+	return upload, nil
+}
+
+// GetCompleteUpload returns the upload with the given ID for u to attach
+// to something. The upload must belong to u and be complete. Otherwise, the
+// error is reported to the client under field.
+func GetCompleteUpload(ctx context.Context, u *User, id, field string) (*Upload, error) {
+	op := errors.Op("models.GetCompleteUpload")
+	invalid := map[string]string{field: "Invalid upload"}
+
+	upload, err := GetUploadByID(ctx, id)
+	if err != nil {
+		return nil, errors.E(op, err, invalid, http.StatusBadRequest)
+	}
+
+	if !upload.OwnerIs(u) || !upload.Complete {
+		return nil, errors.E(op, errors.Str("NotAttachable"), invalid, http.StatusBadRequest)
+	}
+
+	return &upload, nil
+}
+
+// DeleteUploads deletes the given uploads once what they were attached to
+// has been saved. Any transaction of ctx is left out since it must already
+// be committed. Failures are only logged since what was attached is saved.
+func DeleteUploads(ctx context.Context, uploads []*Upload) {
+	ctx = db.WithoutTransaction(ctx)
+	for _, up := range uploads {
+		if err := up.Delete(ctx); err != nil {
+			log.Alarm(errors.E(errors.Op("models.DeleteUploads"), err))
+		}
+	}
+}
+
+func isPhotoType(contentType string) bool {
+	_, ok := _photoTypes[strings.ToLower(contentType)]
+	return ok
+}
+
+// limitedReader reads from r until more than n bytes have been read, at
+// which point it fails so that nothing more is saved.
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			l.exceeded = true
+			return 0, errors.Str("UploadTooLarge")
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	return n, err
+}
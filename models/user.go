@@ -19,33 +19,47 @@ import (
 	notif "github.com/hiconvo/api/notifications"
 	"github.com/hiconvo/api/queue"
 	"github.com/hiconvo/api/search"
+	"github.com/hiconvo/api/utils/i18n"
 	"github.com/hiconvo/api/utils/magic"
 	og "github.com/hiconvo/api/utils/opengraph"
 	"github.com/hiconvo/api/utils/random"
 )
 
 type User struct {
-	Key              *datastore.Key   `json:"-"        datastore:"__key__"`
-	ID               string           `json:"id"       datastore:"-"`
-	Email            string           `json:"email"`
-	Emails           []string         `json:"emails"`
-	FirstName        string           `json:"firstName"`
-	LastName         string           `json:"lastName"`
-	FullName         string           `json:"fullName" datastore:"-"`
-	Token            string           `json:"token"`
-	RealtimeToken    string           `json:"realtimeToken"`
-	PasswordDigest   string           `json:"-"        datastore:",noindex"`
-	OAuthGoogleID    string           `json:"-"`
-	OAuthFacebookID  string           `json:"-"`
-	IsPasswordSet    bool             `json:"isPasswordSet"    datastore:"-"`
-	IsGoogleLinked   bool             `json:"isGoogleLinked"   datastore:"-"`
-	IsFacebookLinked bool             `json:"isFacebookLinked" datastore:"-"`
-	IsLocked         bool             `json:"-"`
-	Verified         bool             `json:"verified"`
-	Avatar           string           `json:"avatar"`
-	ContactKeys      []*datastore.Key `json:"-"`
-	Contacts         []*UserPartial   `json:"-"        datastore:"-"`
-	CreatedAt        time.Time        `json:"-"`
+	Key                    *datastore.Key   `json:"-"        datastore:"__key__"`
+	ID                     string           `json:"id"       datastore:"-"`
+	Email                  string           `json:"email"`
+	Emails                 []string         `json:"emails"`
+	FirstName              string           `json:"firstName"`
+	LastName               string           `json:"lastName"`
+	FullName               string           `json:"fullName" datastore:"-"`
+	Token                  string           `json:"token"`
+	RealtimeToken          string           `json:"realtimeToken"`
+	PasswordDigest         string           `json:"-"        datastore:",noindex"`
+	OAuthGoogleID          string           `json:"-"`
+	OAuthFacebookID        string           `json:"-"`
+	IsPasswordSet          bool             `json:"isPasswordSet"    datastore:"-"`
+	IsGoogleLinked         bool             `json:"isGoogleLinked"   datastore:"-"`
+	IsFacebookLinked       bool             `json:"isFacebookLinked" datastore:"-"`
+	IsLocked               bool             `json:"-"`
+	IsAdmin                bool             `json:"-"`
+	Verified               bool             `json:"verified"`
+	Avatar                 string           `json:"avatar"`
+	ContactKeys            []*datastore.Key `json:"-"`
+	Contacts               []*UserPartial   `json:"-"        datastore:"-"`
+	CreatedAt              time.Time        `json:"-"`
+	NotificationPreference string           `json:"notificationPreference" datastore:",noindex"`
+	Unsubscribed           []string         `json:"unsubscribed"           datastore:",noindex"`
+	BouncingEmails         []string         `json:"bouncingEmails"         datastore:",noindex"`
+	IsEmailBouncing        bool             `json:"isEmailBouncing"        datastore:"-"`
+	Locale                 string           `json:"locale"                 datastore:",noindex"`
+	Timezone               string           `json:"timezone"               datastore:",noindex"`
+	DigestHour             int              `json:"digestHour"             datastore:",noindex"`
+	LastDigestAt           time.Time        `json:"-"                      datastore:",noindex"`
+	NextDigestAt           time.Time        `json:"-"`
+	HideReadReceipts       bool             `json:"hideReadReceipts"       datastore:",noindex"`
+	StorageUsed            int              `json:"storageUsed"            datastore:",noindex"`
+	StorageQuota           int              `json:"storageQuota"           datastore:"-"`
 }
 
 func NewIncompleteUser(email string) (User, error) {
@@ -133,6 +147,10 @@ func (u *User) LoadKey(k *datastore.Key) error {
 }
 
 func (u *User) Save() ([]datastore.Property, error) {
+	// Kept up to date on every save so that the hourly digest task can
+	// query for the users who are due
+	u.NextDigestAt = u.GetNextDigestAt(time.Now())
+
 	return datastore.SaveStruct(u)
 }
 
@@ -239,6 +257,22 @@ func (u *User) DeriveProperties() {
 	u.IsGoogleLinked = u.OAuthGoogleID != ""
 	u.IsFacebookLinked = u.OAuthFacebookID != ""
 
+	// Users who predate notification preferences get the daily digest.
+	if u.NotificationPreference == "" {
+		u.NotificationPreference = NotifyDailyDigest
+	}
+
+	// Emails are rendered in English when no supported locale is set.
+	u.Locale = i18n.Normalize(u.Locale)
+
+	// Users who have never set a time zone get the digest when everyone
+	// used to. The hour is only defaulted along with the time zone since
+	// zero is a valid hour.
+	if u.Timezone == "" {
+		u.Timezone = _defaultTimezone
+		u.DigestHour = _defaultDigestHour
+	}
+
 	// For handling transition from single to multi-email model. If the single email was
 	// verified, add it to the users Emails list.
 	if u.Verified && !u.HasEmail(u.Email) {
@@ -251,6 +285,10 @@ func (u *User) DeriveProperties() {
 	}
 
 	u.Verified = u.HasEmail(u.Email)
+
+	u.IsEmailBouncing = u.IsBouncing(u.Email)
+
+	u.StorageQuota = _userStorageQuota
 }
 
 func (u *User) IsRegistered() bool {
@@ -374,9 +412,49 @@ func (u *User) RemoveEmail(email string) error {
 		}
 	}
 
+	u.ClearBouncing(femail)
+
 	return nil
 }
 
+// IsBouncing returns true if mail to the given address has bounced.
+func (u *User) IsBouncing(email string) bool {
+	femail := strings.ToLower(email)
+
+	for i := range u.BouncingEmails {
+		if u.BouncingEmails[i] == femail {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MarkBouncing flags the given address as one to which mail bounces.
+func (u *User) MarkBouncing(email string) {
+	femail := strings.ToLower(email)
+
+	if !u.IsBouncing(femail) {
+		u.BouncingEmails = append(u.BouncingEmails, femail)
+	}
+
+	u.IsEmailBouncing = u.IsBouncing(u.Email)
+}
+
+// ClearBouncing removes the bouncing flag from the given address.
+func (u *User) ClearBouncing(email string) {
+	femail := strings.ToLower(email)
+
+	for i := range u.BouncingEmails {
+		if u.BouncingEmails[i] == femail {
+			u.BouncingEmails = append(u.BouncingEmails[:i], u.BouncingEmails[i+1:]...)
+			break
+		}
+	}
+
+	u.IsEmailBouncing = u.IsBouncing(u.Email)
+}
+
 func (u *User) MakeEmailPrimary(email string) error {
 	if !u.HasEmail(email) {
 		return errors.E(errors.Op("models.MakeEmailPrimary"), http.StatusBadRequest, map[string]string{
@@ -385,6 +463,7 @@ func (u *User) MakeEmailPrimary(email string) error {
 
 	u.Email = strings.ToLower(email)
 	u.Verified = true
+	u.IsEmailBouncing = u.IsBouncing(u.Email)
 
 	return nil
 }
@@ -408,7 +487,7 @@ func (u *User) Welcome(ctx context.Context) {
 		return
 	}
 
-	message, err := NewThreadMessage(_supportUser, &thread, _welcomeMessage, "", og.LinkData{})
+	message, err := NewThreadMessage(_supportUser, &thread, _welcomeMessage, nil, nil, og.LinkData{}, nil)
 	if err != nil {
 		log.Alarm(errors.E(op, err))
 		return
@@ -426,38 +505,28 @@ func (u *User) Welcome(ctx context.Context) {
 	}
 }
 
+// SendDigest emails the user a digest of their unread messages and upcoming
+// events, if there are any. Nothing is sent if the user's next digest isn't
+// due yet, such as when the task that sends it is run again.
 func (u *User) SendDigest(ctx context.Context) error {
-	events, err := GetEventsByUser(ctx, u, &Pagination{})
-	if err != nil {
-		return err
+	now := time.Now()
+	if u.NextDigestAt.IsZero() || u.NextDigestAt.After(now) {
+		return nil
 	}
 
-	threads, err := GetThreadsByUser(ctx, u, &Pagination{})
+	since := u.GetDigestSince(now)
+
+	digestables, err := GetDigestablesByUser(ctx, u, since)
 	if err != nil {
 		return err
 	}
 
-	// Convert the events into Digestables and filter out read items
-	var digestables []Digestable
-	// Save the upcoming events to a slice at the same time
-	var upcoming []*Event
-	for i := range events {
-		if !IsRead(events[i], u.Key) {
-			digestables = append(digestables, events[i])
-		}
-
-		if events[i].IsUpcoming() {
-			upcoming = append(upcoming, events[i])
-		}
-	}
-
-	for i := range threads {
-		if !IsRead(threads[i], u.Key) {
-			digestables = append(digestables, threads[i])
-		}
+	digestList, err := GenerateDigestList(ctx, digestables, u, since)
+	if err != nil {
+		return err
 	}
 
-	digestList, err := GenerateDigestList(ctx, digestables, u)
+	upcoming, err := GetUpcomingEventsByUser(ctx, u)
 	if err != nil {
 		return err
 	}
@@ -466,10 +535,29 @@ func (u *User) SendDigest(ctx context.Context) error {
 		if err := sendDigest(digestList, upcoming, u); err != nil {
 			return err
 		}
+	}
+
+	// Messages up to now have been digested, so the next digest only needs
+	// to look at what comes after. This is saved even if there was nothing
+	// to send so that the user's next digest is scheduled. Only the digest
+	// times are changed so that nothing that was saved meanwhile is lost.
+	if _, err := db.DefaultClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var fresh User
+		if err := tx.Get(u.Key, &fresh); err != nil {
+			return err
+		}
 
-		if err := MarkDigestedMessagesAsRead(ctx, digestList, u); err != nil {
+		fresh.LastDigestAt = now
+		if _, err := tx.Put(u.Key, &fresh); err != nil {
 			return err
 		}
+
+		u.LastDigestAt = fresh.LastDigestAt
+		u.NextDigestAt = fresh.NextDigestAt
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
 	return nil
@@ -509,6 +597,12 @@ func (u *User) MergeWith(ctx context.Context, oldUser *User) error {
 			return err
 		}
 
+		// Reads
+		err = reassignReads(ctx, tx, oldUser, u)
+		if err != nil {
+			return err
+		}
+
 		// User details
 		if oldUser.Avatar != "" && u.Avatar == "" {
 			u.Avatar = oldUser.Avatar
@@ -622,6 +716,22 @@ func GetUserByID(ctx context.Context, id string) (User, error) {
 	return u, nil
 }
 
+func GetUserByInt64ID(ctx context.Context, id int64) (User, error) {
+	u := User{}
+
+	key := datastore.IDKey("User", id, nil)
+
+	if err := db.DefaultClient.Get(ctx, key, &u); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return u, errors.E(errors.Op("models.GetUserByInt64ID"), http.StatusNotFound, err)
+		}
+
+		return u, err
+	}
+
+	return u, nil
+}
+
 func GetUserByEmail(ctx context.Context, email string) (User, bool, error) {
 	femail := strings.ToLower(email)
 
@@ -45,6 +45,10 @@ const (
 
 	// NewMessage is a notification type that means a new message was sent.
 	NewMessage verb = "NewMessage"
+	// EditMessage is a notification type that means a message was edited.
+	EditMessage verb = "EditMessage"
+	// Mention is a notification type that means someone was mentioned in a message.
+	Mention verb = "Mention"
 
 	// Thread is a notification target that associates the notification with a thread object.
 	Thread target = "thread"
@@ -136,3 +140,13 @@ func FilterKey(keys []*datastore.Key, toFilter *datastore.Key) []*datastore.Key
 
 	return filtered
 }
+
+// FilterKeys returns keys without any of those in toFilter.
+func FilterKeys(keys []*datastore.Key, toFilter []*datastore.Key) []*datastore.Key {
+	filtered := keys
+	for i := range toFilter {
+		filtered = FilterKey(filtered, toFilter[i])
+	}
+
+	return filtered
+}
@@ -32,12 +32,18 @@ const (
 	// SendUpdatedInvites denotes a SendUpdatedInvites actoin, for use in an EmailPayload.
 	// It can only be used when Event is the type.
 	SendUpdatedInvites emailAction = "SendUpdatedInvites"
+	// SendMentions denotes a SendMentions action, for use in an EmailPayload.
+	// It can only be used when Event is the type.
+	SendMentions emailAction = "SendMentions"
 	// SendThread denotes a SendThread actoin, for use in an EmailPayload.
 	// It can only be used when Thread is the type.
 	SendThread emailAction = "SendThread"
 	// SendWelcome denotes a SendWelcome actoin, for use in an EmailPayload
 	// It can only be used when User is the type.
 	SendWelcome emailAction = "SendWelcome"
+	// SendDigest denotes a SendDigest action, for use in an EmailPayload.
+	// It can only be used when User is the type.
+	SendDigest emailAction = "SendDigest"
 )
 
 var DefaultClient Client
@@ -87,9 +93,9 @@ func NewClient(ctx context.Context, projectID string) Client {
 func (c *clientImpl) PutEmail(ctx context.Context, payload EmailPayload) error {
 	if payload.Type == Thread && payload.Action != SendThread {
 		return fmt.Errorf("queue.PutEmail: '%v' is not a valid action for emailType.Thread", payload.Action)
-	} else if payload.Type == Event && !(payload.Action == SendInvites || payload.Action == SendUpdatedInvites) {
+	} else if payload.Type == Event && !(payload.Action == SendInvites || payload.Action == SendUpdatedInvites || payload.Action == SendMentions) {
 		return fmt.Errorf("queue.PutEmail: '%v' is not a valid action for emailType.Event", payload.Action)
-	} else if payload.Type == User && payload.Action != SendWelcome {
+	} else if payload.Type == User && !(payload.Action == SendWelcome || payload.Action == SendDigest) {
 		return fmt.Errorf("queue.PutEmail: '%v' is not a valid action for emailType.User", payload.Action)
 	}
 
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/log"
+)
+
+// GarbageReport is what CollectGarbage found, or would have deleted in a
+// dry run.
+type GarbageReport struct {
+	DryRun  bool            `json:"dryRun"`
+	Scanned int             `json:"scanned"`
+	Orphans []*OrphanedBlob `json:"orphans"`
+	Bytes   int64           `json:"bytes"`
+	Failed  int             `json:"failed"`
+}
+
+// OrphanedBlob is a file that nothing refers to anymore.
+type OrphanedBlob struct {
+	Bucket  string    `json:"bucket"`
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// CollectGarbage deletes the files in the avatar and photo buckets whose keys
+// aren't among the given ones and that are older than gracePeriod, which
+// leaves time for files that were just saved to be referred to. Other sizes
+// of photos are kept along with the full size, and parts of uploads along
+// with the upload. In a dry run, nothing is deleted.
+func (c *Client) CollectGarbage(ctx context.Context, keys []string, gracePeriod time.Duration, dryRun bool) (*GarbageReport, error) {
+	op := errors.Op("storage.CollectGarbage")
+
+	referenced := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		referenced[key] = struct{}{}
+	}
+
+	report := &GarbageReport{DryRun: dryRun, Orphans: []*OrphanedBlob{}}
+	cutoff := time.Now().Add(-gracePeriod)
+
+	// Both buckets are the same directory in development
+	bucketNames := []string{c.avatarBucketName}
+	if c.photoBucketName != c.avatarBucketName {
+		bucketNames = append(bucketNames, c.photoBucketName)
+	}
+
+	for _, bucketName := range bucketNames {
+		if err := c.collectBucketGarbage(ctx, bucketName, referenced, cutoff, report); err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	return report, nil
+}
+
+func (c *Client) collectBucketGarbage(
+	ctx context.Context,
+	bucketName string,
+	referenced map[string]struct{},
+	cutoff time.Time,
+	report *GarbageReport,
+) error {
+	op := errors.Op("storage.collectBucketGarbage")
+
+	bucket, err := c.openBucket(ctx, bucketName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	defer bucket.Close()
+
+	iter := bucket.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.E(op, err)
+		}
+
+		if obj.IsDir {
+			continue
+		}
+
+		report.Scanned++
+
+		if isReferenced(referenced, obj.Key) || obj.ModTime.After(cutoff) {
+			continue
+		}
+
+		if !report.DryRun {
+			if err := bucket.Delete(ctx, obj.Key); err != nil {
+				log.Alarm(errors.E(op, errors.Errorf("could not delete key='%v': %v", obj.Key, err)))
+				report.Failed++
+				continue
+			}
+		}
+
+		report.Orphans = append(report.Orphans, &OrphanedBlob{
+			Bucket:  getURLPrefix(bucketName),
+			Key:     obj.Key,
+			Size:    obj.Size,
+			ModTime: obj.ModTime,
+		})
+		report.Bytes += obj.Size
+	}
+
+	return nil
+}
+
+// isReferenced returns true if key is among the referenced keys, or if it
+// belongs to a file that is.
+func isReferenced(referenced map[string]struct{}, key string) bool {
+	if _, ok := referenced[key]; ok {
+		return true
+	}
+
+	// Smaller sizes of photos go by the key of the full size
+	for _, size := range _photoSizes[1:] {
+		suffix := "-" + size.name + ".jpg"
+		if strings.HasSuffix(key, suffix) {
+			full := photoSizeKey(strings.TrimSuffix(key, suffix), _photoSizes[0].name)
+			if _, ok := referenced[full]; ok {
+				return true
+			}
+		}
+	}
+
+	// Parts of uploads go by the key of the upload
+	if i := strings.LastIndex(key, ".part"); i > 0 && strings.HasPrefix(key, "uploads/") {
+		if _, ok := referenced[key[:i]]; ok {
+			return true
+		}
+	}
+
+	return false
+}
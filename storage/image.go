@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+
+	// Register the formats that photos and avatars can be uploaded in.
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/hiconvo/api/errors"
+)
+
+const (
+	_avatarSize    = 256
+	_avatarQuality = 90
+	_photoQuality  = 70
+)
+
+// _photoSizes are the sizes that are saved of each photo, largest first.
+// Each is the largest that the photo can be on either side.
+var _photoSizes = []struct {
+	name string
+	max  int
+}{
+	{"full", 2048},
+	{"medium", 1024},
+	{"thumbnail", 320},
+}
+
+// Images larger than these are refused before they are decoded, since a
+// small file can have dimensions that take gigabytes to decode.
+const (
+	_maxImageSide   = 20000
+	_maxImagePixels = 50000000
+)
+
+// _exifPeekSize is how much of the start of an image is searched for EXIF
+// data. The EXIF segment of a JPEG can't be larger than 64 KB.
+const _exifPeekSize = 64 << 10
+
+// decodeImage decodes the image that is read from r and rotates it as its
+// EXIF orientation says, so that it is the right way up once the EXIF data
+// is gone. None of the metadata of the original is kept. Images that are
+// too large are refused before they are decoded.
+func decodeImage(r io.Reader) (image.Image, error) {
+	op := errors.Op("storage.decodeImage")
+
+	br := bufio.NewReaderSize(r, _exifPeekSize)
+	head, _ := br.Peek(_exifPeekSize)
+	orientation := exifOrientation(head)
+
+	// What is read for the config is read again to decode the image
+	var config bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(br, &config))
+	if err != nil {
+		return nil, errors.E(op, err, http.StatusBadRequest)
+	}
+
+	if cfg.Width > _maxImageSide || cfg.Height > _maxImageSide || cfg.Width*cfg.Height > _maxImagePixels {
+		return nil, errors.E(op, errors.Str("ImageTooLarge"),
+			map[string]string{"message": "Images can be up to 50 megapixels"},
+			http.StatusBadRequest)
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&config, br))
+	if err != nil {
+		return nil, errors.E(op, err, http.StatusBadRequest)
+	}
+
+	return orient(img, orientation), nil
+}
+
+// encodeJPEG writes img to w as a JPEG of the given quality. Transparent
+// parts of img are made white.
+func encodeJPEG(w io.Writer, img image.Image, quality int) error {
+	if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+		b := img.Bounds()
+		flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+		img = flat
+	}
+
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// crop returns the square of img with the given size whose top left corner
+// is at x, y. It is clipped to img. If size isn't positive, img is returned
+// as is.
+func crop(img image.Image, size, x, y int) image.Image {
+	if size <= 0 {
+		return img
+	}
+
+	b := img.Bounds()
+	r := image.Rect(x, y, x+size, y+size).Add(b.Min).Intersect(b)
+	if r.Empty() {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+
+	return dst
+}
+
+// fit scales img, keeping its proportions, so that neither side is larger
+// than max. Smaller images are only enlarged if enlarge is true.
+func fit(img image.Image, max int, enlarge bool) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 || (w <= max && h <= max && !enlarge) {
+		return img
+	}
+
+	dw, dh := max, max
+	if w > h {
+		dh = h * max / w
+	} else if h > w {
+		dw = w * max / h
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	if dw == w && dh == h {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	return dst
+}
+
+// orient transforms img as the given EXIF orientation says it should be
+// displayed. Orientations 5 to 8 swap the width and height.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	// Copying pixels between RGBA images is much faster than using At and
+	// Set on whatever img is.
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Flip horizontally
+				sx, sy = w-1-x, y
+			case 3: // Rotate 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // Flip vertically
+				sx, sy = x, h-1-y
+			case 5: // Transpose
+				sx, sy = y, x
+			case 6: // Rotate 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // Rotate 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):])
+		}
+	}
+
+	return dst
+}
+
+// exifOrientation returns the EXIF orientation of the JPEG that starts with
+// head, or 1, which means no change, if it doesn't have one.
+func exifOrientation(head []byte) int {
+	if len(head) < 4 || head[0] != 0xFF || head[1] != 0xD8 {
+		return 1
+	}
+
+	// Walk the segments before the image data looking for the APP1 one
+	for i := 2; i+4 <= len(head) && head[i] == 0xFF; {
+		marker := head[i+1]
+		length := int(binary.BigEndian.Uint16(head[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(head) {
+			break
+		}
+
+		seg := head[i+4 : i+2+length]
+		if marker == 0xE1 && len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+
+		i += 2 + length
+	}
+
+	return 1
+}
+
+// tiffOrientation returns the orientation tag of the first IFD of the given
+// TIFF data, which is how EXIF data is laid out.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		// The orientation tag is a SHORT, which is kept in the value field
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 1
+}
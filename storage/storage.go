@@ -5,28 +5,43 @@ multiple storage buckets.
 
 There are two "types" of keys: avatar keys and photo keys. The images corresponding
 to avatar keys are stored in a public bucket. The images corresponding to photo
-keys are also currently stored in a dfferent public bucket, but at some point it is hoped that
-this bucket will be made private and that only signed URLs will be used to access
-the images.
+keys are stored in a different, private bucket, and are only read with signed URLs
+that expire after an hour. Other files that are attached to messages are kept in the
+photo bucket too, and their keys work the same way as photo keys. Signed URLs are
+cached so that files aren't signed again every time they are read.
+
+In development, files are kept in a local directory instead. Its signed URLs point at
+the API itself, which serves them at /files.
+
+Files can also be uploaded ahead of time, whole or in parts, and streamed from the
+request body into the photo bucket under an upload key. Uploads are then copied to
+where they belong with the *FromUpload functions.
+
+Avatars and photos are decoded, turned the right way up, resized and re-encoded as
+JPEGs, which drops their EXIF data, including where they were taken. Each photo is
+saved in several sizes. Its key is that of the full size, from which the keys of the
+others are derived.
 */
 package storage
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	uuid "github.com/gofrs/uuid"
 	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
 
 	// This sets up the plumbing to use blob with the local file system in development mode.
-	_ "gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/fileblob"
 	// This sets up the plumbing to use blob with GCS in production.
 	_ "gocloud.dev/blob/gcsblob"
 
@@ -37,6 +52,11 @@ import (
 
 const _nullKey string = "null-key"
 
+var _imageWriterOptions = &blob.WriterOptions{
+	CacheControl: "525600",
+	ContentType:  "image/jpeg",
+}
+
 var DefaultClient *Client
 
 func init() {
@@ -46,6 +66,10 @@ func init() {
 type Client struct {
 	avatarBucketName string
 	photoBucketName  string
+	signedURLs       *urlCache
+	// localSigner signs the URLs of files in local buckets, which are
+	// served by the API itself at /files.
+	localSigner *fileblob.URLSignerHMAC
 }
 
 func NewClient(avatarBucketName, photoBucketName string) *Client {
@@ -54,12 +78,15 @@ func NewClient(avatarBucketName, photoBucketName string) *Client {
 		return &Client{
 			avatarBucketName: localBucketName,
 			photoBucketName:  localBucketName,
+			signedURLs:       newURLCache(),
+			localSigner:      newLocalSigner(),
 		}
 	}
 
 	return &Client{
 		avatarBucketName: avatarBucketName,
 		photoBucketName:  photoBucketName,
+		signedURLs:       newURLCache(),
 	}
 }
 
@@ -81,27 +108,182 @@ func initLocalStorageDir() string {
 	return fp
 }
 
+func newLocalSigner() *fileblob.URLSignerHMAC {
+	op := errors.Op("storage.newLocalSigner")
+
+	baseURL, err := url.Parse(secrets.Get("API_URL", "http://localhost:8080") + "/files")
+	if err != nil {
+		panic(errors.E(op, err))
+	}
+
+	// Without a configured secret, URLs are only good until the server
+	// restarts, which is fine for development.
+	secret := []byte(secrets.Get("APP_SECRET", ""))
+	if len(secret) == 0 {
+		secret = uuid.Must(uuid.NewV4()).Bytes()
+	}
+
+	return fileblob.NewURLSignerHMAC(baseURL, secret)
+}
+
 // GetAvatarURLFromKey returns the public URL of the given avatar key.
 func (c *Client) GetAvatarURLFromKey(key string) string {
 	return getURLPrefix(c.avatarBucketName) + key
 }
 
-// GetPhotoURLFromKey returns the public URL of the given photo key.
+// GetPhotoURLFromKey returns the public URL of the given photo key. Since
+// the photo bucket is private, the URL only works once signed, but its last
+// two segments are the key, as GetKeyFromPhotoURL expects.
 func (c *Client) GetPhotoURLFromKey(key string) string {
 	return getURLPrefix(c.photoBucketName) + key
 }
 
+// PhotoURLs are the signed URLs of the sizes of a photo.
+type PhotoURLs struct {
+	Thumbnail string `json:"thumbnail"`
+	Medium    string `json:"medium"`
+	Full      string `json:"full"`
+}
+
+// GetSignedPhotoURLs returns signed URLs of the sizes of each of the given
+// photos, in the same order. Photos that were saved before there were
+// several sizes only have one, which is used for all of them.
+func (c *Client) GetSignedPhotoURLs(ctx context.Context, keys []string) ([]*PhotoURLs, error) {
+	sizeKeys := make([]string, 0, len(keys)*len(_photoSizes))
+	for _, key := range keys {
+		base, ok := photoKeyBase(key)
+		for _, size := range _photoSizes {
+			if ok {
+				sizeKeys = append(sizeKeys, photoSizeKey(base, size.name))
+			} else {
+				sizeKeys = append(sizeKeys, key)
+			}
+		}
+	}
+
+	urls, err := c.signURLs(ctx, sizeKeys)
+	if err != nil {
+		return nil, errors.E(errors.Op("storage.GetSignedPhotoURLs"), err)
+	}
+
+	photos := make([]*PhotoURLs, len(keys))
+	for i := range keys {
+		sizes := urls[i*len(_photoSizes) : (i+1)*len(_photoSizes)]
+		photos[i] = &PhotoURLs{Full: sizes[0], Medium: sizes[1], Thumbnail: sizes[2]}
+	}
+
+	return photos, nil
+}
+
+// GetSignedFileURLs returns signed URLs of the given files, in the same
+// order. Files are kept in the photo bucket alongside photos.
+func (c *Client) GetSignedFileURLs(ctx context.Context, keys []string) ([]string, error) {
+	urls, err := c.signURLs(ctx, keys)
+	if err != nil {
+		return nil, errors.E(errors.Op("storage.GetSignedFileURLs"), err)
+	}
+
+	return urls, nil
+}
+
 // GetSignedPhotoURL returns a signed URL of the given photo key.
 func (c *Client) GetSignedPhotoURL(ctx context.Context, key string) (string, error) {
-	b, err := blob.OpenBucket(ctx, c.photoBucketName)
+	urls, err := c.signURLs(ctx, []string{key})
 	if err != nil {
 		return "", errors.E(errors.Op("storage.GetSignedPhotoURL"), err)
 	}
-	defer b.Close()
 
-	return b.SignedURL(ctx, key, &blob.SignedURLOptions{
-		Expiry: blob.DefaultSignedURLExpiry,
-	})
+	return urls[0], nil
+}
+
+// signURLs returns signed URLs of the given keys of the photo bucket. URLs
+// that were signed recently are reused.
+func (c *Client) signURLs(ctx context.Context, keys []string) ([]string, error) {
+	op := errors.Op("storage.signURLs")
+
+	var bucket *blob.Bucket
+	defer func() {
+		if bucket != nil {
+			bucket.Close()
+		}
+	}()
+
+	urls := make([]string, len(keys))
+	for i, key := range keys {
+		if u, ok := c.signedURLs.get(key); ok {
+			urls[i] = u
+			continue
+		}
+
+		if bucket == nil {
+			var err error
+			bucket, err = c.openBucket(ctx, c.photoBucketName)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+		}
+
+		u, err := bucket.SignedURL(ctx, key, &blob.SignedURLOptions{
+			Expiry: _signedURLExpiry,
+		})
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		c.signedURLs.put(key, u)
+		urls[i] = u
+	}
+
+	return urls, nil
+}
+
+// SignedFile is a file of a local bucket that was opened from a signed URL.
+type SignedFile struct {
+	io.ReadCloser
+	ContentType        string
+	ContentDisposition string
+}
+
+// OpenSignedFile opens the file that the given signed URL is for. It only
+// works with local buckets, whose signed URLs point at the API itself.
+// Signed URLs of other buckets point at their own storage service.
+func (c *Client) OpenSignedFile(ctx context.Context, u *url.URL) (*SignedFile, error) {
+	op := errors.Op("storage.OpenSignedFile")
+
+	if c.localSigner == nil {
+		return nil, errors.E(op, errors.Str("NotLocal"), http.StatusNotFound)
+	}
+
+	key, err := c.localSigner.KeyFromURL(ctx, u)
+	if err != nil {
+		return nil, errors.E(op, err, http.StatusNotFound)
+	}
+
+	bucket, err := c.openBucket(ctx, c.photoBucketName)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	attrs, err := bucket.Attributes(ctx, key)
+	if err != nil {
+		bucket.Close()
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, errors.E(op, err, http.StatusNotFound)
+		}
+		return nil, errors.E(op, err)
+	}
+
+	r, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		bucket.Close()
+		return nil, errors.E(op, err)
+	}
+
+	return &SignedFile{
+		ReadCloser:         &bucketReader{Reader: r, bucket: bucket},
+		ContentType:        attrs.ContentType,
+		ContentDisposition: attrs.ContentDisposition,
+	}, nil
 }
 
 // GetKeyFromAvatarURL accepts a url and returns the last segment of the
@@ -116,15 +298,29 @@ func (c *Client) GetKeyFromAvatarURL(url string) string {
 	return ss[len(ss)-1]
 }
 
-// GetKeyFromPhotoURL accepts a url and returns the last segment of the
-// URL, which corresponds with the key of the image, used in cloud
-// storage.
-func (c *Client) GetKeyFromPhotoURL(url string) string {
-	if url == "" {
+// GetKeyFromPhotoURL accepts a url and returns the last two segments of
+// its path, which correspond with the key of the image, used in cloud
+// storage. Signed URLs of local buckets have the key in their query string
+// instead.
+func (c *Client) GetKeyFromPhotoURL(photoURL string) string {
+	if photoURL == "" {
+		return _nullKey
+	}
+
+	u, err := url.Parse(photoURL)
+	if err != nil {
+		return _nullKey
+	}
+
+	if key := u.Query().Get("obj"); key != "" {
+		return key
+	}
+
+	ss := strings.Split(u.Path, "/")
+	if len(ss) < 2 {
 		return _nullKey
 	}
 
-	ss := strings.Split(url, "/")
 	return ss[len(ss)-2] + "/" + ss[len(ss)-1]
 }
 
@@ -137,77 +333,77 @@ func (c *Client) PutAvatarFromURL(ctx context.Context, uri string) (string, erro
 	if err != nil {
 		return "", err
 	}
+	defer res.Body.Close()
+
 	if res.StatusCode != http.StatusOK {
 		return "", errors.E(op, errors.Str("Could not download avatar image"))
 	}
 
-	key := uuid.Must(uuid.NewV4()).String() + ".jpg"
-
-	bucket, err := blob.OpenBucket(ctx, c.avatarBucketName)
+	avatarURL, err := c.putAvatar(ctx, res.Body, 0, 0, 0, "")
 	if err != nil {
 		return "", errors.E(op, err)
 	}
-	defer bucket.Close()
 
-	outputBlob, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{
-		CacheControl: "525600",
-	})
+	return avatarURL, nil
+}
+
+// PutAvatarFromBlob crops and resizes the given image blob, saves it, and
+// returns the full URL of the image.
+func (c *Client) PutAvatarFromBlob(ctx context.Context, dat string, size, x, y int, oldKey string) (string, error) {
+	inputBlob := base64.NewDecoder(base64.StdEncoding, strings.NewReader(dat))
+
+	avatarURL, err := c.putAvatar(ctx, inputBlob, size, x, y, oldKey)
 	if err != nil {
-		return "", errors.E(op, err)
+		return "", errors.E(errors.Op("storage.PutAvatarFromBlob"), err)
 	}
-	defer outputBlob.Close()
 
-	var stderr bytes.Buffer
+	return avatarURL, nil
+}
 
-	cmd := exec.Command("convert", "-", "-adaptive-resize", "256x256", "jpeg:-")
-	cmd.Stdin = res.Body
-	cmd.Stdout = outputBlob
-	cmd.Stderr = &stderr
+// PutAvatarFromUpload is like PutAvatarFromBlob, but it reads the image from
+// the upload with the given key.
+func (c *Client) PutAvatarFromUpload(ctx context.Context, uploadKey string, size, x, y int, oldKey string) (string, error) {
+	op := errors.Op("storage.PutAvatarFromUpload")
 
-	if err := cmd.Run(); err != nil {
-		log.Print(stderr.String())
+	inputBlob, err := c.openUpload(ctx, uploadKey)
+	if err != nil {
 		return "", errors.E(op, err)
 	}
+	defer inputBlob.Close()
 
-	return c.GetAvatarURLFromKey(key), nil
-}
-
-// PutAvatarFromBlob crops and resizes the given image blob, saves it, and
-// returns the full URL of the image.
-func (c *Client) PutAvatarFromBlob(ctx context.Context, dat string, size, x, y int, oldKey string) (string, error) {
-	op := errors.Op("storage.PutAvatarFromBlob")
-
-	bucket, err := blob.OpenBucket(ctx, c.avatarBucketName)
+	avatarURL, err := c.putAvatar(ctx, inputBlob, size, x, y, oldKey)
 	if err != nil {
 		return "", errors.E(op, err)
 	}
-	defer bucket.Close()
 
-	key := uuid.Must(uuid.NewV4()).String() + ".jpg"
+	return avatarURL, nil
+}
 
-	outputBlob, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{
-		CacheControl: "525600",
-	})
+func (c *Client) putAvatar(ctx context.Context, inputBlob io.Reader, size, x, y int, oldKey string) (string, error) {
+	op := errors.Op("storage.putAvatar")
+
+	img, err := decodeImage(inputBlob)
 	if err != nil {
 		return "", errors.E(op, err)
 	}
-	defer outputBlob.Close()
 
-	inputBlob := base64.NewDecoder(base64.StdEncoding, strings.NewReader(dat))
-	var stderr bytes.Buffer
+	img = fit(crop(img, size, x, y), _avatarSize, true)
 
-	cropGeo := fmt.Sprintf("%vx%v+%v+%v", size, size, x, y)
-	cmd := exec.Command("convert", "-", "-crop", cropGeo, "-adaptive-resize", "256x256", "jpeg:-")
-	cmd.Stdin = inputBlob
-	cmd.Stdout = outputBlob
-	cmd.Stderr = &stderr
+	key := uuid.Must(uuid.NewV4()).String() + ".jpg"
 
-	if err := cmd.Run(); err != nil {
-		log.Print(stderr.String())
+	if err := c.write(ctx, c.avatarBucketName, key, _imageWriterOptions, func(w io.Writer) error {
+		return encodeJPEG(w, img, _avatarQuality)
+	}); err != nil {
 		return "", errors.E(op, err)
 	}
 
 	if oldKey != "" && oldKey != _nullKey {
+		bucket, err := c.openBucket(ctx, c.avatarBucketName)
+		if err != nil {
+			return "", errors.E(op, err)
+		}
+		defer bucket.Close()
+
 		exists, err := bucket.Exists(ctx, oldKey)
 		if err != nil {
 			log.Alarm(errors.E(op, err))
@@ -222,62 +418,358 @@ func (c *Client) PutAvatarFromBlob(ctx context.Context, dat string, size, x, y i
 
 // PutPhotoFromBlob resizes the given image blob, saves it, and returns full url of the image.
 func (c *Client) PutPhotoFromBlob(ctx context.Context, parentID, dat string) (string, error) {
-	op := errors.Op("storage.PutPhotoFromBlob")
+	inputBlob := base64.NewDecoder(base64.StdEncoding, strings.NewReader(dat))
+
+	photoURL, err := c.putPhoto(ctx, parentID, inputBlob)
+	if err != nil {
+		return "", errors.E(errors.Op("storage.PutPhotoFromBlob"), err)
+	}
+
+	return photoURL, nil
+}
+
+// PutPhotoFromUpload is like PutPhotoFromBlob, but it reads the image from
+// the upload with the given key.
+func (c *Client) PutPhotoFromUpload(ctx context.Context, parentID, uploadKey string) (string, error) {
+	op := errors.Op("storage.PutPhotoFromUpload")
+
+	inputBlob, err := c.openUpload(ctx, uploadKey)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	defer inputBlob.Close()
+
+	photoURL, err := c.putPhoto(ctx, parentID, inputBlob)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+
+	return photoURL, nil
+}
+
+func (c *Client) putPhoto(ctx context.Context, parentID string, inputBlob io.Reader) (string, error) {
+	op := errors.Op("storage.putPhoto")
 
 	if parentID == "" {
 		return "", errors.E(op, errors.Str("No parentID given"))
 	}
 
-	bucket, err := blob.OpenBucket(ctx, c.photoBucketName)
+	img, err := decodeImage(inputBlob)
 	if err != nil {
 		return "", errors.E(op, err)
 	}
-	defer bucket.Close()
 
-	key := parentID + "/" + uuid.Must(uuid.NewV4()).String() + ".jpg"
+	// Each size is scaled down from the one before it
+	base := parentID + "/" + uuid.Must(uuid.NewV4()).String()
+	for _, size := range _photoSizes {
+		img = fit(img, size.max, false)
 
-	outputBlob, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{
-		CacheControl: "525600",
-	})
+		if err := c.write(ctx, c.photoBucketName, photoSizeKey(base, size.name), _imageWriterOptions, func(w io.Writer) error {
+			return encodeJPEG(w, img, _photoQuality)
+		}); err != nil {
+			return "", errors.E(op, err)
+		}
+	}
+
+	return c.GetPhotoURLFromKey(photoSizeKey(base, _photoSizes[0].name)), nil
+}
+
+// PutFileFromBlob saves the given base64 encoded file as is and returns
+// its key. Like photos, files are stored under the ID of the thread or
+// event they were sent to. They are served with the given content type and
+// download under the given name instead of being displayed.
+func (c *Client) PutFileFromBlob(ctx context.Context, parentID, name, contentType, dat string) (string, error) {
+	inputBlob := base64.NewDecoder(base64.StdEncoding, strings.NewReader(dat))
+
+	key, err := c.putFile(ctx, parentID, name, contentType, inputBlob)
+	if err != nil {
+		return "", errors.E(errors.Op("storage.PutFileFromBlob"), err)
+	}
+
+	return key, nil
+}
+
+// PutFileFromUpload is like PutFileFromBlob, but it reads the file from the
+// upload with the given key.
+func (c *Client) PutFileFromUpload(ctx context.Context, parentID, name, contentType, uploadKey string) (string, error) {
+	op := errors.Op("storage.PutFileFromUpload")
+
+	inputBlob, err := c.openUpload(ctx, uploadKey)
 	if err != nil {
 		return "", errors.E(op, err)
 	}
-	defer outputBlob.Close()
+	defer inputBlob.Close()
 
-	inputBlob := base64.NewDecoder(base64.StdEncoding, strings.NewReader(dat))
-	var stderr bytes.Buffer
+	key, err := c.putFile(ctx, parentID, name, contentType, inputBlob)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
 
-	cmd := exec.Command("convert", "-", "-resize", "2048x2048>", "-quality", "70", "jpeg:-")
-	cmd.Stdin = inputBlob
-	cmd.Stdout = outputBlob
-	cmd.Stderr = &stderr
+	return key, nil
+}
 
-	if err := cmd.Run(); err != nil {
-		log.Print(stderr.String())
+func (c *Client) putFile(ctx context.Context, parentID, name, contentType string, inputBlob io.Reader) (string, error) {
+	op := errors.Op("storage.putFile")
+
+	if parentID == "" {
+		return "", errors.E(op, errors.Str("No parentID given"))
+	}
+
+	key := parentID + "/" + uuid.Must(uuid.NewV4()).String() + strings.ToLower(filepath.Ext(name))
+
+	if err := c.write(ctx, c.photoBucketName, key, &blob.WriterOptions{
+		CacheControl:       "525600",
+		ContentType:        contentType,
+		ContentDisposition: mime.FormatMediaType("attachment", map[string]string{"filename": name}),
+	}, copyFrom(inputBlob)); err != nil {
 		return "", errors.E(op, err)
 	}
 
-	return c.GetPhotoURLFromKey(key), nil
+	return key, nil
+}
+
+// NewUploadKey returns a new key for an upload of a file with the given
+// name. Uploads are kept in the photo bucket until they are attached to
+// something, at which point they are copied to where they belong.
+func (c *Client) NewUploadKey(name string) string {
+	return "uploads/" + uuid.Must(uuid.NewV4()).String() + strings.ToLower(filepath.Ext(name))
+}
+
+// PutUpload saves everything that is read from r under the given upload key
+// and returns how many bytes were read. If reading fails, nothing is saved.
+func (c *Client) PutUpload(ctx context.Context, key string, r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+
+	if err := c.write(ctx, c.photoBucketName, key, nil, copyFrom(counter)); err != nil {
+		return 0, errors.E(errors.Op("storage.PutUpload"), err)
+	}
+
+	return counter.n, nil
+}
+
+// PutUploadPart is like PutUpload, but it saves r as the given part of the
+// upload. Saving the same part again replaces it.
+func (c *Client) PutUploadPart(ctx context.Context, key string, part int, r io.Reader) (int64, error) {
+	n, err := c.PutUpload(ctx, uploadPartKey(key, part), r)
+	if err != nil {
+		return 0, errors.E(errors.Op("storage.PutUploadPart"), err)
+	}
+
+	return n, nil
+}
+
+// CombineUploadParts joins the given number of parts of the upload, in
+// order, into the upload itself. The parts are deleted once joined.
+func (c *Client) CombineUploadParts(ctx context.Context, key string, parts int) error {
+	op := errors.Op("storage.CombineUploadParts")
+
+	bucket, err := c.openBucket(ctx, c.photoBucketName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	defer bucket.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < parts; i++ {
+			r, err := bucket.NewReader(ctx, uploadPartKey(key, i), nil)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			_, err = io.Copy(pw, r)
+			r.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+
+		pw.Close()
+	}()
+
+	if err := c.write(ctx, c.photoBucketName, key, nil, copyFrom(pr)); err != nil {
+		// Unblock the goroutine if the write stopped reading early.
+		pr.CloseWithError(err)
+		return errors.E(op, err)
+	}
+
+	for i := 0; i < parts; i++ {
+		if err := bucket.Delete(ctx, uploadPartKey(key, i)); err != nil {
+			log.Alarm(errors.E(op, err))
+		}
+	}
+
+	return nil
+}
+
+// DeleteUpload deletes the upload with the given key along with the given
+// number of parts of it. Parts and uploads that don't exist are skipped.
+func (c *Client) DeleteUpload(ctx context.Context, key string, parts int) error {
+	op := errors.Op("storage.DeleteUpload")
+
+	bucket, err := c.openBucket(ctx, c.photoBucketName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	defer bucket.Close()
+
+	keys := []string{key}
+	for i := 0; i < parts; i++ {
+		keys = append(keys, uploadPartKey(key, i))
+	}
+
+	for _, k := range keys {
+		if err := bucket.Delete(ctx, k); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return errors.E(op, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) openUpload(ctx context.Context, key string) (io.ReadCloser, error) {
+	op := errors.Op("storage.openUpload")
+
+	bucket, err := c.openBucket(ctx, c.photoBucketName)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	r, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		bucket.Close()
+		return nil, errors.E(op, err)
+	}
+
+	return &bucketReader{Reader: r, bucket: bucket}, nil
+}
+
+// openBucket opens the bucket with the given name. Local buckets are given
+// the signer of their URLs.
+func (c *Client) openBucket(ctx context.Context, bucketName string) (*blob.Bucket, error) {
+	if strings.HasPrefix(bucketName, "file://") {
+		return fileblob.OpenBucket(strings.TrimPrefix(bucketName, "file://"), &fileblob.Options{
+			URLSigner: c.localSigner,
+		})
+	}
+
+	return blob.OpenBucket(ctx, bucketName)
+}
+
+// write saves what fn writes to the given bucket under the given key.
+func (c *Client) write(ctx context.Context, bucketName, key string, opts *blob.WriterOptions, fn func(w io.Writer) error) error {
+	op := errors.Op("storage.write")
+
+	bucket, err := c.openBucket(ctx, bucketName)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	defer bucket.Close()
+
+	// Cancelling the context of the writer discards what was written, so
+	// that a file that can't be written in full isn't saved in part.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outputBlob, err := bucket.NewWriter(ctx, key, opts)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := fn(outputBlob); err != nil {
+		cancel()
+		outputBlob.Close()
+		return errors.E(op, err)
+	}
+
+	if err := outputBlob.Close(); err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
 }
 
-// DeletePhoto deletes the given photo from the photo bucket.
-// This does not work for avatars.
+// DeletePhoto deletes the given photo, along with its other sizes, from the
+// photo bucket. This does not work for avatars.
 func (c *Client) DeletePhoto(ctx context.Context, key string) error {
 	op := errors.Op("storage.DeletePhoto")
 
-	bucket, err := blob.OpenBucket(ctx, c.photoBucketName)
+	bucket, err := c.openBucket(ctx, c.photoBucketName)
 	if err != nil {
 		return errors.E(op, err)
 	}
 	defer bucket.Close()
 
-	if err := bucket.Delete(ctx, key); err != nil {
-		return errors.E(op, err)
+	keys := []string{key}
+	if base, ok := photoKeyBase(key); ok {
+		for _, size := range _photoSizes[1:] {
+			keys = append(keys, photoSizeKey(base, size.name))
+		}
+	}
+
+	for _, k := range keys {
+		if err := bucket.Delete(ctx, k); err != nil {
+			return errors.E(op, err)
+		}
 	}
 
 	return nil
 }
 
+// copyFrom returns a function for write that copies r.
+func copyFrom(r io.Reader) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	}
+}
+
+// photoSizeKey returns the key of the given size of the photo whose keys
+// start with base.
+func photoSizeKey(base, size string) string {
+	return base + "-" + size + ".jpg"
+}
+
+// photoKeyBase returns what the keys of the sizes of the given photo start
+// with, if it has several sizes.
+func photoKeyBase(key string) (string, bool) {
+	suffix := "-" + _photoSizes[0].name + ".jpg"
+	if !strings.HasSuffix(key, suffix) {
+		return "", false
+	}
+
+	return strings.TrimSuffix(key, suffix), true
+}
+
+func uploadPartKey(key string, part int) string {
+	return fmt.Sprintf("%s.part%d", key, part)
+}
+
+// countingReader counts the bytes that are read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// bucketReader closes the bucket that it reads from when it is closed.
+type bucketReader struct {
+	*blob.Reader
+	bucket *blob.Bucket
+}
+
+func (r *bucketReader) Close() error {
+	defer r.bucket.Close()
+	return r.Reader.Close()
+}
+
 // getURLPrefix returns the public URL prefix of the given bucket.
 // For example, it will convert "gs://convo-avatars" to
 // "https://storage.googleapis.com/convo-avatarts/".
@@ -287,7 +779,9 @@ func getURLPrefix(bucketName string) string {
 	}
 
 	if bucketName[:5] == "gs://" {
-		return fmt.Sprintf("https://storage.googleapis.com/%s/", bucketName[5:])
+		// Drop the signing options of the bucket, if there are any
+		name := strings.SplitN(bucketName[5:], "?", 2)[0]
+		return fmt.Sprintf("https://storage.googleapis.com/%s/", name)
 	}
 
 	panic(errors.E(
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// _signedURLExpiry is how long signed URLs work for.
+const _signedURLExpiry = time.Hour
+
+// _urlCacheSweepSize is how many URLs the cache holds before expired ones
+// are removed.
+const _urlCacheSweepSize = 10000
+
+// urlCache keeps signed URLs so that each file isn't signed again every time
+// that it is read. A URL is only reused while at least half of its lifetime
+// is left, so that clients always have a while to use it.
+type urlCache struct {
+	mu   sync.Mutex
+	urls map[string]cachedURL
+}
+
+type cachedURL struct {
+	url     string
+	expires time.Time
+}
+
+func newURLCache() *urlCache {
+	return &urlCache{urls: make(map[string]cachedURL)}
+}
+
+func (uc *urlCache) get(key string) (string, bool) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	cached, ok := uc.urls[key]
+	if !ok || time.Until(cached.expires) < _signedURLExpiry/2 {
+		return "", false
+	}
+
+	return cached.url, true
+}
+
+func (uc *urlCache) put(key, url string) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	if len(uc.urls) >= _urlCacheSweepSize {
+		now := time.Now()
+		for k, cached := range uc.urls {
+			if now.After(cached.expires) {
+				delete(uc.urls, k)
+			}
+		}
+
+		// Start over rather than sweep again on every put
+		if len(uc.urls) >= _urlCacheSweepSize {
+			uc.urls = make(map[string]cachedURL)
+		}
+	}
+
+	uc.urls[key] = cachedURL{url: url, expires: time.Now().Add(_signedURLExpiry)}
+}
@@ -6,13 +6,18 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/hiconvo/api/utils/i18n"
 )
 
-var templates map[string]*htmltpl.Template
+// templates maps each supported locale to its parsed templates by name. The
+// templates are parsed once per locale so that the "t" function can be bound
+// to that locale's catalog.
+var templates map[string]map[string]*htmltpl.Template
 
 func init() {
 	if templates == nil {
-		templates = make(map[string]*htmltpl.Template)
+		templates = make(map[string]map[string]*htmltpl.Template)
 	}
 
 	wd, err := os.Getwd()
@@ -40,9 +45,16 @@ func init() {
 	}
 
 	// Generate our templates map from our layouts/ and includes/ directories
-	for _, layout := range layouts {
-		files := append(includes, layout)
-		templates[filepath.Base(layout)] = htmltpl.Must(htmltpl.ParseFiles(files...))
+	for _, locale := range i18n.Locales() {
+		templates[locale] = make(map[string]*htmltpl.Template)
+
+		for _, layout := range layouts {
+			files := append(includes, layout)
+			name := filepath.Base(layout)
+			templates[locale][name] = htmltpl.Must(htmltpl.New(name).
+				Funcs(funcs(locale)).
+				ParseFiles(files...))
+		}
 	}
 
 	// Make sure the expected templates are there
@@ -51,11 +63,25 @@ func init() {
 		"event.html",
 		"cancellation.html",
 		"digest.html",
+		"admin.html",
 	} {
-		_, ok := templates[tplName]
+		_, ok := templates[i18n.Default][tplName]
 
 		if !ok {
 			panic(fmt.Sprintf("Template '%v' not found", tplName))
 		}
 	}
 }
+
+// funcs returns the functions available to templates rendered in the given
+// locale.
+func funcs(locale string) htmltpl.FuncMap {
+	return htmltpl.FuncMap{
+		"locale": func() string {
+			return locale
+		},
+		"t": func(key string, args ...interface{}) string {
+			return i18n.T(locale, key, args...)
+		},
+	}
+}
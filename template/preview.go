@@ -0,0 +1,118 @@
+package template
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/utils/i18n"
+)
+
+// Kinds of email that can be previewed.
+const (
+	PreviewThread       = "thread"
+	PreviewEvent        = "event"
+	PreviewCancellation = "cancellation"
+	PreviewDigest       = "digest"
+	PreviewAdmin        = "admin"
+)
+
+// PreviewKinds lists every kind of email RenderPreview can render.
+var PreviewKinds = []string{
+	PreviewThread,
+	PreviewEvent,
+	PreviewCancellation,
+	PreviewDigest,
+	PreviewAdmin,
+}
+
+const (
+	_previewMagicLink       = "https://app.convo.events/magic/preview"
+	_previewUnsubscribeLink = "https://api.convo.events/unsubscribe/preview"
+)
+
+// _previewTime is fixed so that previews, and the golden files made from
+// them, don't change from one run to the next.
+var _previewTime = time.Date(2020, time.March, 14, 19, 30, 0, 0, time.UTC)
+
+// RenderPreview renders the given kind of email in the given locale using
+// fixture data. It returns the same plain text and HTML as the matching
+// Render function, so it can be used to look at template changes without
+// sending anything.
+func RenderPreview(kind, locale string) (string, string, error) {
+	switch kind {
+	case PreviewThread:
+		return RenderThread(previewThread(locale))
+	case PreviewEvent:
+		return RenderEvent(previewEvent(locale))
+	case PreviewCancellation:
+		e := previewEvent(locale)
+		e.Message = i18n.T(locale, "preview.cancellation")
+		return RenderCancellation(e)
+	case PreviewDigest:
+		thread := previewThread(locale)
+		event := previewEvent(locale)
+		return RenderDigest(Digest{
+			Items:           []Thread{thread},
+			Events:          []Event{event},
+			MagicLink:       _previewMagicLink,
+			UnsubscribeLink: _previewUnsubscribeLink,
+			Locale:          locale,
+		})
+	case PreviewAdmin:
+		return RenderAdminEmail(AdminEmail{
+			Body:       i18n.T(locale, "preview.admin"),
+			ButtonText: i18n.T(locale, "admin.verify"),
+			MagicLink:  _previewMagicLink,
+			Fargs:      []interface{}{"ada@example.com", "ada.lovelace@example.com"},
+			Locale:     locale,
+		})
+	default:
+		return "", "", errors.E(
+			errors.Op("template.RenderPreview"),
+			errors.Errorf("unknown kind %q", kind),
+			http.StatusNotFound)
+	}
+}
+
+func previewThread(locale string) Thread {
+	return Thread{
+		Subject:  i18n.T(locale, "preview.subject"),
+		FromName: "Ada Lovelace",
+		Messages: []Message{
+			{
+				Body:   i18n.T(locale, "preview.question"),
+				Name:   "Ada",
+				FromID: "ada",
+				ToID:   "charles",
+			},
+			{
+				Body:        i18n.T(locale, "preview.answer"),
+				Name:        "Charles",
+				FromID:      "charles",
+				ToID:        "charles",
+				HasPhoto:    true,
+				HasLink:     true,
+				ReplyToName: "Ada",
+				ReplyToBody: i18n.T(locale, "preview.question"),
+			},
+		},
+		MagicLink:       _previewMagicLink,
+		UnsubscribeLink: _previewUnsubscribeLink,
+		Locale:          locale,
+	}
+}
+
+func previewEvent(locale string) Event {
+	return Event{
+		Name:            i18n.T(locale, "preview.eventName"),
+		Address:         "1 Infinite Loop, Cupertino, CA 95014",
+		Time:            i18n.FormatTime(locale, _previewTime),
+		Description:     i18n.T(locale, "preview.description"),
+		FromName:        "Ada Lovelace",
+		MagicLink:       _previewMagicLink,
+		ButtonText:      i18n.T(locale, "event.rsvp"),
+		UnsubscribeLink: _previewUnsubscribeLink,
+		Locale:          locale,
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/russross/blackfriday/v2"
 
 	"github.com/hiconvo/api/errors"
+	"github.com/hiconvo/api/utils/i18n"
 )
 
 type renderable struct {
@@ -18,10 +19,12 @@ func (r *renderable) RenderMarkdown(data string) {
 	r.RenderedBody = htmltpl.HTML(blackfriday.Run([]byte(data)))
 }
 
-func (r renderable) RenderHTML(tplName string, data interface{}) (string, error) {
+// RenderHTML renders the named layout in the given locale. Unsupported
+// locales, including the empty string, render in i18n.Default.
+func (r renderable) RenderHTML(tplName, locale string, data interface{}) (string, error) {
 	var op errors.Op = "renderable.RenderHTML"
 
-	tmpl, _ := templates[tplName]
+	tmpl, _ := templates[i18n.Normalize(locale)][tplName]
 
 	var buf bytes.Buffer
 	if err := tmpl.ExecuteTemplate(&buf, "base.html", data); err != nil {
@@ -3,68 +3,77 @@ package template
 import (
 	"fmt"
 	"strings"
-)
 
-const (
-	_tplStrMessage      = "%s said:\n\n%s\n\n"
-	_tplStrEvent        = "%s invited you to:\n\n%s\n\n%s\n\n%s\n\n%s\n"
-	_tplStrCancellation = "%s has cancelled:\n\n%s\n\n%s\n\n%s\n\n%s"
+	"github.com/hiconvo/api/utils/i18n"
 )
 
 // Message is a renderable message. It is always a constituent of a
-// Thread. The Body field accepts markdown. XML is not allowed.
+// Thread. The Body field accepts markdown. XML is not allowed. If the
+// message is a reply, ReplyToName and ReplyToBody are the name of the
+// author of the message it replies to and the part of it that is quoted,
+// which is rendered as plain text.
 type Message struct {
 	renderable
-	Body     string
-	Name     string
-	FromID   string
-	ToID     string
-	HasPhoto bool
-	HasLink  bool
+	Body        string
+	Name        string
+	FromID      string
+	ToID        string
+	HasPhoto    bool
+	HasLink     bool
+	ReplyToName string
+	ReplyToBody string
 }
 
 // Thread is a representation of a renderable email thread.
 type Thread struct {
 	renderable
-	Subject   string
-	FromName  string
-	Messages  []Message
-	Preview   string
-	MagicLink string
+	Subject         string
+	FromName        string
+	Messages        []Message
+	Preview         string
+	MagicLink       string
+	UnsubscribeLink string
+	Locale          string
 }
 
 // Event is a representation of a renderable email event.
 type Event struct {
 	renderable
-	Name        string
-	Address     string
-	Time        string
-	Description string
-	Preview     string
-	FromName    string
-	MagicLink   string
-	ButtonText  string
-	Message     string
+	Name            string
+	Address         string
+	Time            string
+	Description     string
+	Preview         string
+	FromName        string
+	MagicLink       string
+	ButtonText      string
+	Message         string
+	UnsubscribeLink string
+	Locale          string
 }
 
 // Digest is a representation of a renderable email digest.
 type Digest struct {
 	renderable
-	Items     []Thread
-	Preview   string
-	Events    []Event
-	MagicLink string
+	Items           []Thread
+	Preview         string
+	Events          []Event
+	MagicLink       string
+	UnsubscribeLink string
+	Locale          string
 }
 
 // AdminEmail is a representation of a renderable administrative
 // email which includes a call to action button.
 type AdminEmail struct {
 	renderable
-	Body       string
-	ButtonText string
-	MagicLink  string
-	Fargs      []interface{}
-	Preview    string
+	Body            string
+	ButtonText      string
+	MagicLink       string
+	Fargs           []interface{}
+	Preview         string
+	UnsubscribeLink string
+	Locale          string
 }
 
 // RenderThread returns a rendered thread email.
@@ -72,16 +81,22 @@ func RenderThread(t Thread) (string, string, error) {
 	var builder strings.Builder
 
 	for i, m := range t.Messages {
-		fmt.Fprintf(&builder, _tplStrMessage, m.Name, m.Body)
+		if m.ReplyToBody != "" {
+			builder.WriteString(i18n.T(t.Locale, "text.reply",
+				m.Name, m.ReplyToName, quote(m.ReplyToBody), m.Body))
+		} else {
+			builder.WriteString(i18n.T(t.Locale, "text.message", m.Name, m.Body))
+		}
 		t.Messages[i].RenderMarkdown(t.Messages[i].Body)
 	}
 
+	preview := getPreview(builder.String())
+	writeUnsubscribe(&builder, t.Locale, t.UnsubscribeLink)
 	plainText := builder.String()
-	preview := getPreview(plainText)
 
 	t.Preview = preview
 
-	html, err := t.RenderHTML("thread.html", t)
+	html, err := t.RenderHTML("thread.html", t.Locale, t)
 
 	return plainText, html, err
 }
@@ -91,18 +106,19 @@ func RenderEvent(e Event) (string, string, error) {
 	e.RenderMarkdown(e.Description)
 
 	var builder strings.Builder
-	fmt.Fprintf(&builder, _tplStrEvent,
+	builder.WriteString(i18n.T(e.Locale, "text.event",
 		e.FromName,
 		e.Name,
 		e.Address,
 		e.Time,
-		e.Description)
+		e.Description))
+	preview := getPreview(builder.String())
+	writeUnsubscribe(&builder, e.Locale, e.UnsubscribeLink)
 	plainText := builder.String()
-	preview := getPreview(plainText)
 
 	e.Preview = preview
 
-	html, err := e.RenderHTML("event.html", e)
+	html, err := e.RenderHTML("event.html", e.Locale, e)
 
 	return plainText, html, err
 }
@@ -112,18 +128,19 @@ func RenderCancellation(e Event) (string, string, error) {
 	e.RenderMarkdown(e.Message)
 
 	var builder strings.Builder
-	fmt.Fprintf(&builder, _tplStrCancellation,
+	builder.WriteString(i18n.T(e.Locale, "text.cancellation",
 		e.FromName,
 		e.Name,
 		e.Address,
 		e.Time,
-		e.Message)
+		e.Message))
+	preview := getPreview(builder.String())
+	writeUnsubscribe(&builder, e.Locale, e.UnsubscribeLink)
 	plainText := builder.String()
-	preview := getPreview(plainText)
 
 	e.Preview = preview
 
-	html, err := e.RenderHTML("cancellation.html", e)
+	html, err := e.RenderHTML("cancellation.html", e.Locale, e)
 
 	return plainText, html, err
 }
@@ -136,10 +153,13 @@ func RenderDigest(d Digest) (string, string, error) {
 		}
 	}
 
-	plainText := "You have notifications on Convo."
-	d.Preview = plainText
+	var builder strings.Builder
+	builder.WriteString(i18n.T(d.Locale, "digest.notifications"))
+	d.Preview = builder.String()
+	writeUnsubscribe(&builder, d.Locale, d.UnsubscribeLink)
+	plainText := builder.String()
 
-	html, err := d.RenderHTML("digest.html", d)
+	html, err := d.RenderHTML("digest.html", d.Locale, d)
 
 	return plainText, html, err
 }
@@ -148,17 +168,35 @@ func RenderDigest(d Digest) (string, string, error) {
 func RenderAdminEmail(a AdminEmail) (string, string, error) {
 	var builder strings.Builder
 	fmt.Fprintf(&builder, a.Body, a.Fargs...)
+	body := builder.String()
+	preview := getPreview(body)
+	writeUnsubscribe(&builder, a.Locale, a.UnsubscribeLink)
 	plainText := builder.String()
-	preview := getPreview(plainText)
 
 	a.Preview = preview
 
-	a.RenderMarkdown(plainText)
-	html, err := a.RenderHTML("admin.html", a)
+	a.RenderMarkdown(body)
+	html, err := a.RenderHTML("admin.html", a.Locale, a)
 
 	return plainText, html, err
 }
 
+func writeUnsubscribe(builder *strings.Builder, locale, link string) {
+	if link != "" {
+		builder.WriteString(i18n.T(locale, "text.unsubscribe", link))
+	}
+}
+
+// quote prefixes every line of text with "> ", as email clients do.
+func quote(text string) string {
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		lines[i] = "> " + lines[i]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func getPreview(plainText string) string {
 	if len(plainText) > 200 {
 		return plainText[:200] + "..."
@@ -0,0 +1,67 @@
+package template_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hiconvo/api/template"
+	"github.com/hiconvo/api/utils/i18n"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestRenderPreview compares every kind of email in every locale against the
+// golden files in testdata. After changing a template on purpose, run
+//
+//	go test ./template -update
+//
+// and review the diff of testdata.
+func TestRenderPreview(t *testing.T) {
+	for _, kind := range template.PreviewKinds {
+		for _, locale := range i18n.Locales() {
+			kind, locale := kind, locale
+
+			t.Run(fmt.Sprintf("%s/%s", kind, locale), func(t *testing.T) {
+				plainText, html, err := template.RenderPreview(kind, locale)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				name := fmt.Sprintf("%s.%s", kind, locale)
+				assertGolden(t, name+".txt", plainText)
+				assertGolden(t, name+".html", html)
+			})
+		}
+	}
+}
+
+func TestRenderPreviewUnknownKind(t *testing.T) {
+	if _, _, err := template.RenderPreview("nope", i18n.Default); err == nil {
+		t.Fatal("expected an error for an unknown kind")
+	}
+}
+
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+
+	if got != string(want) {
+		t.Errorf("%s does not match the rendered output (run with -update if the change is intended)", path)
+	}
+}
@@ -0,0 +1,73 @@
+package i18n
+
+import "time"
+
+var en = map[string]string{
+	// Shared
+	"hello":             "Hello,",
+	"loginToConvo":      "Login to Convo",
+	"toRespond":         "to respond.",
+	"thanks":            "Thanks,",
+	"convoSupport":      "Convo Support",
+	"unsubscribePrompt": "Don't want these emails?",
+	"unsubscribe":       "Unsubscribe",
+
+	// Messages
+	"message.hasPhoto":    "This message contains a photo.",
+	"message.hasLink":     "This message contains a link.",
+	"message.toView":      "to view it.",
+	"message.replyTo":     "In reply to %s:",
+	"message.deletedUser": "someone",
+
+	// Threads
+	"thread.shared":       "%s shared something with you on Convo.",
+	"thread.footerBefore": "This email is a",
+	"thread.footerAfter":  ". You can reply to this email as you would any other.",
+
+	// Events
+	"event.invited":          "%s has invited you to the following event. Click the button below to RSVP or to send a message to the group.",
+	"event.cancelledTitle":   "Cancelled: %s",
+	"event.cancelledBefore":  "The following event has been",
+	"event.cancelled":        "cancelled",
+	"event.subject":          "Invitation to %s",
+	"event.updatedSubject":   "Updated invitation to %s",
+	"event.cancelledSubject": "Cancelled: %s",
+	"event.rsvp":             "RSVP",
+
+	// Digests
+	"digest.title":          "Convo Digest",
+	"digest.subject":        "[convo] Digest",
+	"digest.messagesEvents": "You have unread messages and upcoming events on Convo.",
+	"digest.events":         "You have upcoming events on Convo.",
+	"digest.messages":       "You have unread messages on Convo.",
+	"digest.upcomingEvents": "Upcoming Events",
+	"digest.unreadMessages": "Unread Messages",
+	"digest.notifications":  "You have notifications on Convo.",
+
+	// Admin emails
+	"admin.title":            "Action required on Convo",
+	"admin.setPassword":      "Set password",
+	"admin.setPasswordTitle": "[convo] Set Password",
+	"admin.verify":           "Verify",
+	"admin.verifyTitle":      "[convo] Verify Email",
+
+	// Previews, which render each kind of email with made up content
+	"preview.subject":      "Dinner on Saturday",
+	"preview.question":     "Who's in for dinner on **Saturday**?",
+	"preview.answer":       "Count me in. Here's the place I was telling you about.",
+	"preview.eventName":    "Pi Day Party",
+	"preview.description":  "Bring a pie. Any pie.\n\n- Sweet\n- Savory",
+	"preview.cancellation": "Sorry everyone, the roof is leaking. We'll *reschedule* soon.",
+	"preview.admin":        "Please click the link below to verify your email address. This will merge %s into %s.",
+
+	// Plain text versions
+	"text.message":      "%s said:\n\n%s\n\n",
+	"text.reply":        "%s replied to %s:\n\n%s\n\n%s\n\n",
+	"text.event":        "%s invited you to:\n\n%s\n\n%s\n\n%s\n\n%s\n",
+	"text.cancellation": "%s has cancelled:\n\n%s\n\n%s\n\n%s\n\n%s",
+	"text.unsubscribe":  "\n\nDon't want these emails? Unsubscribe: %s\n",
+}
+
+func formatTimeEn(t time.Time) string {
+	return t.Format("Monday, January 2 @ 3:04 PM")
+}
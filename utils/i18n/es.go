@@ -0,0 +1,83 @@
+package i18n
+
+import (
+	"fmt"
+	"time"
+)
+
+var es = map[string]string{
+	// Shared
+	"hello":             "Hola:",
+	"loginToConvo":      "Inicia sesión en Convo",
+	"toRespond":         "para responder.",
+	"thanks":            "Gracias,",
+	"convoSupport":      "Soporte de Convo",
+	"unsubscribePrompt": "¿No quieres recibir estos correos?",
+	"unsubscribe":       "Darse de baja",
+
+	// Messages
+	"message.hasPhoto":    "Este mensaje contiene una foto.",
+	"message.hasLink":     "Este mensaje contiene un enlace.",
+	"message.toView":      "para verlo.",
+	"message.replyTo":     "En respuesta a %s:",
+	"message.deletedUser": "alguien",
+
+	// Threads
+	"thread.shared":       "%s compartió algo contigo en Convo.",
+	"thread.footerBefore": "Este correo es un",
+	"thread.footerAfter":  ". Puedes responder a este correo como a cualquier otro.",
+
+	// Events
+	"event.invited":          "%s te ha invitado al siguiente evento. Haz clic en el botón de abajo para confirmar tu asistencia o para enviar un mensaje al grupo.",
+	"event.cancelledTitle":   "Cancelado: %s",
+	"event.cancelledBefore":  "El siguiente evento ha sido",
+	"event.cancelled":        "cancelado",
+	"event.subject":          "Invitación a %s",
+	"event.updatedSubject":   "Invitación actualizada a %s",
+	"event.cancelledSubject": "Cancelado: %s",
+	"event.rsvp":             "Confirmar asistencia",
+
+	// Digests
+	"digest.title":          "Resumen de Convo",
+	"digest.subject":        "[convo] Resumen",
+	"digest.messagesEvents": "Tienes mensajes sin leer y próximos eventos en Convo.",
+	"digest.events":         "Tienes próximos eventos en Convo.",
+	"digest.messages":       "Tienes mensajes sin leer en Convo.",
+	"digest.upcomingEvents": "Próximos eventos",
+	"digest.unreadMessages": "Mensajes sin leer",
+	"digest.notifications":  "Tienes notificaciones en Convo.",
+
+	// Admin emails
+	"admin.title":            "Acción requerida en Convo",
+	"admin.setPassword":      "Establecer contraseña",
+	"admin.setPasswordTitle": "[convo] Establecer contraseña",
+	"admin.verify":           "Verificar",
+	"admin.verifyTitle":      "[convo] Verificar correo",
+
+	// Previews, which render each kind of email with made up content
+	"preview.subject":      "Cena el sábado",
+	"preview.question":     "¿Quién se apunta a cenar el **sábado**?",
+	"preview.answer":       "Cuenten conmigo. Este es el sitio del que les hablaba.",
+	"preview.eventName":    "Fiesta del Día de Pi",
+	"preview.description":  "Traigan una tarta. La que sea.\n\n- Dulce\n- Salada",
+	"preview.cancellation": "Lo siento a todos, hay una gotera en el techo. Lo *reprogramaremos* pronto.",
+	"preview.admin":        "Haz clic en el enlace de abajo para verificar tu dirección de correo. Esto fusionará %s con %s.",
+
+	// Plain text versions
+	"text.message":      "%s dijo:\n\n%s\n\n",
+	"text.reply":        "%s respondió a %s:\n\n%s\n\n%s\n\n",
+	"text.event":        "%s te invitó a:\n\n%s\n\n%s\n\n%s\n\n%s\n",
+	"text.cancellation": "%s ha cancelado:\n\n%s\n\n%s\n\n%s\n\n%s",
+	"text.unsubscribe":  "\n\n¿No quieres recibir estos correos? Darse de baja: %s\n",
+}
+
+var (
+	_esWeekdays = [...]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"}
+	_esMonths   = [...]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio",
+		"agosto", "septiembre", "octubre", "noviembre", "diciembre"}
+)
+
+func formatTimeEs(t time.Time) string {
+	return fmt.Sprintf("%s, %d de %s @ %s",
+		_esWeekdays[t.Weekday()], t.Day(), _esMonths[t.Month()-1], t.Format("15:04"))
+}
@@ -0,0 +1,116 @@
+// Package i18n holds the translated copy used in emails and the helpers
+// needed to pick a user's language.
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported locales.
+const (
+	English = "en"
+	Spanish = "es"
+)
+
+// Default is the locale used when a user has not chosen one, or has chosen
+// one that is not supported.
+const Default = English
+
+type catalog struct {
+	messages   map[string]string
+	formatTime func(t time.Time) string
+}
+
+var catalogs = map[string]catalog{
+	English: {messages: en, formatTime: formatTimeEn},
+	Spanish: {messages: es, formatTime: formatTimeEs},
+}
+
+// Locales returns all supported locales, sorted.
+func Locales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+
+	sort.Strings(locales)
+
+	return locales
+}
+
+// IsSupported returns true if there is a catalog for the given locale.
+func IsSupported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Normalize returns locale if it is supported and Default otherwise.
+func Normalize(locale string) string {
+	if IsSupported(locale) {
+		return locale
+	}
+
+	return Default
+}
+
+// T returns the message with the given key in the given locale, formatted
+// with args as by fmt.Sprintf. Messages missing from a catalog fall back to
+// English, and unknown keys are returned as is so that they are easy to spot.
+func T(locale, key string, args ...interface{}) string {
+	msg, ok := catalogs[Normalize(locale)].messages[key]
+	if !ok {
+		msg, ok = catalogs[Default].messages[key]
+	}
+
+	if !ok {
+		return key
+	}
+
+	if len(args) == 0 {
+		return msg
+	}
+
+	return fmt.Sprintf(msg, args...)
+}
+
+// FormatTime formats t for display in emails in the given locale. The
+// caller is responsible for converting t to the desired time zone.
+func FormatTime(locale string, t time.Time) string {
+	return catalogs[Normalize(locale)].formatTime(t)
+}
+
+// Negotiate picks the best supported locale for the given Accept-Language
+// header. Region subtags are ignored, so "es-MX" matches Spanish. If nothing
+// matches, Default is returned.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		if i := strings.IndexAny(tag, "-_"); i >= 0 {
+			tag = tag[:i]
+		}
+
+		if IsSupported(tag) && q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+
+	return best
+}
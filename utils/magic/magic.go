@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/datastore"
@@ -19,6 +20,14 @@ import (
 
 var secret = secrets.Get("APP_SECRET", "")
 
+const (
+	_unsubscribeSalt = "unsubscribe"
+	_replySalt       = "reply"
+	// Email local parts are limited to 64 characters, so reply tokens
+	// only carry a truncated signature.
+	_replySigLen = 12
+)
+
 func NewLink(k *datastore.Key, salt, action string) string {
 	// Get time and convert to epoc string
 	ts := time.Now().Unix()
@@ -76,6 +85,63 @@ func TooOld(b64ts string) error {
 	return nil
 }
 
+// NewUnsubscribeLink returns a link to the API endpoint that opts the user
+// out of the given category of emails. Unlike the links returned by NewLink,
+// it never expires since it may be followed long after the email was sent.
+func NewUnsubscribeLink(k *datastore.Key, category string) string {
+	kenc := k.Encode()
+
+	return fmt.Sprintf("https://api.convo.events/unsubscribe/%s/%s/%s",
+		category, kenc, getSignature(kenc, category, _unsubscribeSalt))
+}
+
+// VerifyUnsubscribe verifies the signature of a link created by
+// NewUnsubscribeLink.
+func VerifyUnsubscribe(kenc, category, sig string) error {
+	if hmac.Equal([]byte(sig), []byte(getSignature(kenc, category, _unsubscribeSalt))) {
+		return nil
+	}
+
+	return errors.E(errors.Op("magic.VerifyUnsubscribe"), http.StatusUnauthorized, errors.Str("InvalidSignature"))
+}
+
+// NewReplyToken returns a token identifying the given thread and recipient.
+// It is short enough to be embedded in the local part of an email address
+// so that replies can be attributed to the user the email was sent to.
+func NewReplyToken(threadKey, userKey *datastore.Key) string {
+	uid := strconv.FormatInt(threadKey.ID, 36) + "." + strconv.FormatInt(userKey.ID, 36)
+	return uid + "." + getSignature(uid, "", _replySalt)[:_replySigLen]
+}
+
+// ParseReplyToken verifies a token created by NewReplyToken and returns the
+// int64 IDs of the thread and user it was issued for.
+func ParseReplyToken(token string) (int64, int64, error) {
+	op := errors.Op("magic.ParseReplyToken")
+
+	parts := strings.Split(strings.ToLower(token), ".")
+	if len(parts) != 3 {
+		return 0, 0, errors.E(op, http.StatusBadRequest, errors.Str("MalformedToken"))
+	}
+
+	uid := parts[0] + "." + parts[1]
+	expected := getSignature(uid, "", _replySalt)[:_replySigLen]
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return 0, 0, errors.E(op, http.StatusUnauthorized, errors.Str("InvalidSignature"))
+	}
+
+	threadID, err := strconv.ParseInt(parts[0], 36, 64)
+	if err != nil {
+		return 0, 0, errors.E(op, http.StatusBadRequest, err)
+	}
+
+	userID, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil {
+		return 0, 0, errors.E(op, http.StatusBadRequest, err)
+	}
+
+	return threadID, userID, nil
+}
+
 func getSignature(uid, b64ts, salt string) string {
 	h := hmac.New(sha256.New, []byte(secret))
 	h.Write([]byte(uid + b64ts + salt))
@@ -29,9 +29,9 @@ type ProviderPayload struct {
 // Verify both verifies the fiven oauth token and retrieves needed info about
 // the user.
 func Verify(ctx context.Context, payload UserPayload) (ProviderPayload, error) {
-	// FIXME: If a user changes her email via an oauth party, we will not know
-	// about it. Need to monitor for bounced emails and show message on web ui
-	// to prompt user to update email in that case.
+	// If a user changes their email via an oauth party, we will not know
+	// about it. Bounces are reported by the email webhook, which flags the
+	// address on the user so that the web ui can prompt them to update it.
 	if payload.Provider == "google" {
 		return verifyGoogleToken(ctx, payload)
 	}
@@ -63,12 +63,20 @@ func AddressesFromEnvelope(payload string) (string, string, error) {
 	return toAddress.Address, fromAddress.Address, nil
 }
 
-func ThreadInt64IDFromAddress(to string) (int64, error) {
+// ReplyTokenFromAddress returns the reply token embedded in the local part
+// of the given address, which is everything after the last hyphen.
+func ReplyTokenFromAddress(to string) string {
 	split := strings.Split(to, "@")
 	toName := split[0]
 	nameSplit := strings.Split(toName, "-")
-	ID := nameSplit[len(nameSplit)-1]
-	return strconv.ParseInt(ID, 10, 64)
+	return nameSplit[len(nameSplit)-1]
+}
+
+// IsLegacyReplyToken returns true if token is a bare thread ID, which is
+// what reply addresses held before they carried signed reply tokens.
+func IsLegacyReplyToken(token string) bool {
+	_, err := strconv.ParseInt(token, 10, 64)
+	return err == nil
 }
 
 func MessageText(htmlBody, textBody, from, to string) (string, error) {